@@ -0,0 +1,287 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+// errDetached is returned instead of an exit status when the client was
+// detached from a task with the detach keys, leaving the task running
+var errDetached = errors.New("detached from task")
+
+// flag for the commands which attach our stdin to a task
+var detachKeysFlag = cli.StringFlag{
+	Name:  "detach-keys",
+	Usage: "key sequence (e.g. ctrl-p,ctrl-q) which detaches us from the container, leaving it running; empty to disable",
+	Value: defaultDetachKeys,
+}
+
+var attachCommand = cli.Command{
+	Name:      "attach",
+	Usage:     "attach our stdio to a container's running task",
+	ArgsUsage: "CONTAINER",
+	Flags: []cli.Flag{
+		detachKeysFlag,
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "do not attach our stdin to the task",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		name := clicontext.Args().First()
+		if name == "" {
+			return errors.New("container name must be provided")
+		}
+		detachKeys, err := parseDetachKeys(clicontext.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		container, err := c.client.LoadContainer(c.ctx, name)
+		if err != nil {
+			return err
+		}
+		if err := c.loadSpec(container); err != nil {
+			return err
+		}
+		c.stdin = !clicontext.Bool("no-stdin")
+		c.detachKeys = detachKeys
+
+		sigc := catchSignals()
+		defer stopCatch(sigc)
+		exitStatus, err := c.attachContainer(container, sigc)
+		if err == errDetached {
+			log.Infof("detached from container %s", name)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if exitStatus.Error() != nil {
+			log.Errorf("container exited with error: %v", exitStatus.Error())
+		}
+		if code := exitStatus.ExitCode(); code != 0 {
+			return cli.NewExitError("", int(code))
+		}
+		return nil
+	},
+}
+
+// attachContainer attaches our stdio to the FIFOs of the container's existing
+// task and waits on it like a task started in the foreground. Once the task
+// exits it is deleted, since the client which started it may have detached or
+// exited in the meantime.
+func (c *cc) attachContainer(container containerd.Container, sigc chan os.Signal) (_ containerd.ExitStatus, err error) {
+	var con console.Console
+	if c.tty {
+		if con, err = currentConsole(); err != nil {
+			return containerd.ExitStatus{}, err
+		}
+		defer con.Reset()
+		if err := con.SetRaw(); err != nil {
+			return containerd.ExitStatus{}, errors.Wrap(err, "error setting terminal to raw mode")
+		}
+	}
+
+	var stdinC *stdinCloser
+	task, err := container.Task(c.ctx, func(fifos *cio.FIFOSet) (cio.IO, error) {
+		ioAttach, sc, err := c.attachIO(container, fifos, con)
+		if err != nil {
+			return nil, err
+		}
+		stdinC = sc
+		return ioAttach(fifos)
+	})
+	if err != nil {
+		return containerd.ExitStatus{}, errors.Wrapf(err, "error attaching to container %s", container.ID())
+	}
+	if stdinC != nil {
+		stdinC.closer = func() {
+			task.CloseIO(c.ctx, containerd.WithStdinCloser)
+		}
+	}
+	defer func() {
+		if err == errDetached {
+			return
+		}
+		if _, err := task.Delete(c.cleanupContext()); err != nil && !errdefs.IsNotFound(err) {
+			log.Errorf("error deleting task: %v", err)
+		}
+	}()
+
+	statusC, err := task.Wait(c.ctx)
+	if err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error waiting on task")
+	}
+	return c.waitAttached(task, con, statusC, sigc)
+}
+
+// attachIO returns the IO attacher for the FIFOs of an existing task. Our
+// stdin is only attached if the task was created with one.
+func (c *cc) attachIO(container containerd.Container, fifos *cio.FIFOSet, con console.Console) (cio.Attach, *stdinCloser, error) {
+	if fifos.Stdout == "" && fifos.Stderr == "" {
+		return nil, nil, errors.Errorf("the task of container %s has no IO to attach to", container.ID())
+	}
+	if strings.Contains(fifos.Stdout, "://") {
+		return nil, nil, errors.Errorf("the output of container %s is logged; use logs to read it", container.ID())
+	}
+	if fifos.Terminal != (con != nil) {
+		return nil, nil, errors.Errorf("the TTY mode of container %s doesn't match its task", container.ID())
+	}
+	var stdin io.Reader
+	var stdinC *stdinCloser
+	if c.stdin && fifos.Stdin != "" {
+		if con != nil {
+			stdin = c.detachable(con)
+		} else {
+			stdinC = &stdinCloser{stdin: c.detachable(os.Stdin)}
+			stdin = stdinC
+		}
+	}
+	if con != nil {
+		return cio.NewAttach(cio.WithStreams(stdin, con, nil), cio.WithTerminal), nil, nil
+	}
+	return cio.NewAttach(cio.WithStreams(stdin, os.Stdout, os.Stderr)), stdinC, nil
+}
+
+// waitAttached forwards signals and console size changes to a task or exec
+// process until it exits, returning its exit status, or until the client is
+// detached from it
+func (c *cc) waitAttached(task containerd.Process, con console.Console, statusC <-chan containerd.ExitStatus, sigc chan os.Signal) (containerd.ExitStatus, error) {
+	forwardSignals(c.ctx, task, sigc)
+	if con != nil {
+		stopResize, err := handleConsoleResize(c.ctx, task, con)
+		if err != nil {
+			log.Errorf("error resizing console: %v", err)
+		} else {
+			defer stopResize()
+		}
+	}
+	select {
+	case exitStatus := <-statusC:
+		return exitStatus, nil
+	case <-c.detached:
+		return containerd.ExitStatus{}, errDetached
+	}
+}
+
+// detachable wraps the reader of our input so that reading the client's
+// detach keys from it detaches the client from the task
+func (c *cc) detachable(r io.Reader) io.Reader {
+	if len(c.detachKeys) == 0 {
+		return r
+	}
+	dr := &detachReader{
+		r:        r,
+		keys:     c.detachKeys,
+		detached: make(chan struct{}),
+	}
+	c.detached = dr.detached
+	return dr
+}
+
+// detachReader passes input through until the detach key sequence is read,
+// then closes detached and stops reading. Input which may be the start of the
+// sequence is held back until it is known not to be.
+type detachReader struct {
+	r        io.Reader
+	keys     []byte
+	detached chan struct{}
+	matched  int
+	pending  []byte
+	done     bool
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	if d.done {
+		return 0, errDetached
+	}
+	n, err := d.r.Read(p)
+	var out []byte
+	for _, b := range p[:n] {
+		if b == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				d.done = true
+				close(d.detached)
+				break
+			}
+			continue
+		}
+		out = append(out, d.keys[:d.matched]...)
+		d.matched = 0
+		if b == d.keys[0] {
+			d.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	if err != nil && d.matched > 0 && !d.done {
+		out = append(out, d.keys[:d.matched]...)
+		d.matched = 0
+	}
+	n = copy(p, out)
+	d.pending = out[n:]
+	if d.done {
+		if n == 0 {
+			return 0, errDetached
+		}
+		return n, nil
+	}
+	if len(d.pending) > 0 {
+		// the error is returned once the held back input has been read
+		return n, nil
+	}
+	return n, err
+}
+
+// parseDetachKeys parses a comma separated sequence of keys, each either a
+// single character or ctrl- followed by a letter or one of @[\]^_
+func parseDetachKeys(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			k := key[5]
+			switch {
+			case k >= 'a' && k <= 'z':
+				keys = append(keys, k-'a'+1)
+			case k >= 'A' && k <= 'Z':
+				keys = append(keys, k-'A'+1)
+			case strings.IndexByte("@[\\]^_", k) >= 0:
+				keys = append(keys, k-'@')
+			default:
+				return nil, errors.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, errors.Errorf("invalid detach key %q", key)
+		}
+	}
+	return keys, nil
+}
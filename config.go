@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	defaultConfigPath = "/etc/examplectr/config.toml"
+	defaultStateDir   = "/var/lib/examplectr"
+)
+
+// config holds the settings used to connect to the containerd daemon. The
+// top-level values apply to every invocation and may be overridden by a named
+// profile, then by environment variables and finally by command line flags.
+type config struct {
+	Address        string             `toml:"address" yaml:"address"`
+	Namespace      string             `toml:"namespace" yaml:"namespace"`
+	ConnectTimeout duration           `toml:"connect_timeout" yaml:"connect_timeout"`
+	Timeout        duration           `toml:"timeout" yaml:"timeout"`
+	LogDir         string             `toml:"log_dir" yaml:"log_dir"`
+	StateDir       string             `toml:"state_dir" yaml:"state_dir"`
+	Profile        string             `toml:"profile" yaml:"profile"`
+	Profiles       map[string]profile `toml:"profiles" yaml:"profiles"`
+}
+
+// profile is a named set of overrides for the top-level config settings
+type profile struct {
+	Address        string   `toml:"address" yaml:"address"`
+	Namespace      string   `toml:"namespace" yaml:"namespace"`
+	ConnectTimeout duration `toml:"connect_timeout" yaml:"connect_timeout"`
+	Timeout        duration `toml:"timeout" yaml:"timeout"`
+}
+
+// duration wraps time.Duration so it can be decoded from strings like "10s"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// flags used to locate the daemon; each can also be set from the environment
+var configFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "config,c",
+		Usage:  "path to a TOML or YAML config file",
+		Value:  defaultConfigPath,
+		EnvVar: "EXAMPLECTR_CONFIG",
+	},
+	cli.StringFlag{
+		Name:   "profile,p",
+		Usage:  "named profile from the config file to use",
+		EnvVar: "EXAMPLECTR_PROFILE",
+	},
+	cli.StringFlag{
+		Name:   "address,a",
+		Usage:  "address of the containerd daemon socket",
+		Value:  defaultContainerdPath,
+		EnvVar: "CONTAINERD_ADDRESS",
+	},
+	cli.StringFlag{
+		Name:   "namespace,n",
+		Usage:  "containerd namespace to use",
+		Value:  defaultNamespace,
+		EnvVar: "CONTAINERD_NAMESPACE",
+	},
+	cli.DurationFlag{
+		Name:  "connect-timeout",
+		Usage: "timeout for connecting to the containerd daemon",
+		Value: defaultConnectTimeout,
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "total timeout for a command (0 for none)",
+	},
+	cli.StringFlag{
+		Name:  "log-dir",
+		Usage: "directory for the log files of detached containers",
+		Value: defaultLogDir,
+	},
+	cli.StringFlag{
+		Name:  "state-dir",
+		Usage: "directory for examplectr's local state, such as user namespace allocations",
+		Value: defaultStateDir,
+	},
+}
+
+// loadConfig builds the effective configuration from the defaults, the config
+// file, the selected profile, the environment and the command line flags
+func loadConfig(clicontext *cli.Context) (*config, error) {
+	cfg := &config{
+		Address:        defaultContainerdPath,
+		Namespace:      defaultNamespace,
+		ConnectTimeout: duration{defaultConnectTimeout},
+		LogDir:         defaultLogDir,
+		StateDir:       defaultStateDir,
+	}
+
+	path := clicontext.GlobalString("config")
+	if err := cfg.decodeFile(path); err != nil {
+		// a missing default config file is not an error
+		if !os.IsNotExist(errors.Cause(err)) || clicontext.GlobalIsSet("config") {
+			return nil, errors.Wrapf(err, "error loading config file %s", path)
+		}
+	}
+
+	name := cfg.Profile
+	if clicontext.GlobalIsSet("profile") {
+		name = clicontext.GlobalString("profile")
+	}
+	if name != "" {
+		p, ok := cfg.Profiles[name]
+		if !ok {
+			return nil, errors.Errorf("profile %q not found in config file %s", name, path)
+		}
+		cfg.apply(p)
+	}
+
+	if clicontext.GlobalIsSet("address") {
+		cfg.Address = clicontext.GlobalString("address")
+	}
+	if clicontext.GlobalIsSet("namespace") {
+		cfg.Namespace = clicontext.GlobalString("namespace")
+	}
+	if clicontext.GlobalIsSet("connect-timeout") {
+		cfg.ConnectTimeout.Duration = clicontext.GlobalDuration("connect-timeout")
+	}
+	if clicontext.GlobalIsSet("timeout") {
+		cfg.Timeout.Duration = clicontext.GlobalDuration("timeout")
+	}
+	if clicontext.GlobalIsSet("log-dir") {
+		cfg.LogDir = clicontext.GlobalString("log-dir")
+	}
+	if clicontext.GlobalIsSet("state-dir") {
+		cfg.StateDir = clicontext.GlobalString("state-dir")
+	}
+	return cfg, nil
+}
+
+// decodeFile reads the config file at path, choosing the YAML decoder for
+// .yaml and .yml files and TOML for anything else
+func (cfg *config) decodeFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return yaml.UnmarshalStrict(data, cfg)
+	default:
+		_, err = toml.Decode(string(data), cfg)
+		return err
+	}
+}
+
+// apply overrides the top-level settings with those set in a profile
+func (cfg *config) apply(p profile) {
+	if p.Address != "" {
+		cfg.Address = p.Address
+	}
+	if p.Namespace != "" {
+		cfg.Namespace = p.Namespace
+	}
+	if p.ConnectTimeout.Duration != 0 {
+		cfg.ConnectTimeout = p.ConnectTimeout
+	}
+	if p.Timeout.Duration != 0 {
+		cfg.Timeout = p.Timeout
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/containerd/console"
+	"github.com/pkg/errors"
+)
+
+// resizer is implemented by tasks and processes which have a console that can
+// be resized
+type resizer interface {
+	Resize(ctx context.Context, w, h uint32) error
+}
+
+// stdinCloser closes the task's stdin once our stdin reaches EOF, so that a
+// process reading its input sees the end of it
+type stdinCloser struct {
+	stdin  io.Reader
+	closer func()
+}
+
+func (s *stdinCloser) Read(p []byte) (int, error) {
+	n, err := s.stdin.Read(p)
+	if err == io.EOF && s.closer != nil {
+		s.closer()
+	}
+	return n, err
+}
+
+// currentConsole returns our stdin as a console, failing if it isn't a terminal
+func currentConsole() (console.Console, error) {
+	con, err := console.ConsoleFromFile(os.Stdin)
+	if err != nil {
+		return nil, errors.Wrap(err, "the input device is not a TTY")
+	}
+	return con, nil
+}
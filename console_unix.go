@@ -0,0 +1,43 @@
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/containerd/console"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleConsoleResize sizes the task's console to match ours and keeps it in
+// sync whenever we receive SIGWINCH. The returned function stops the resizing.
+func handleConsoleResize(ctx context.Context, task resizer, con console.Console) (func(), error) {
+	size, err := con.Size()
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Resize(ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+		log.Errorf("error resizing console: %v", err)
+	}
+	s := make(chan os.Signal, 16)
+	signal.Notify(s, syscall.SIGWINCH)
+	go func() {
+		for range s {
+			size, err := con.Size()
+			if err != nil {
+				log.Errorf("error getting console size: %v", err)
+				continue
+			}
+			if err := task.Resize(ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+				log.Errorf("error resizing console: %v", err)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(s)
+		close(s)
+	}, nil
+}
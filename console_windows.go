@@ -0,0 +1,22 @@
+// +build windows
+
+package main
+
+import (
+	"context"
+
+	"github.com/containerd/console"
+)
+
+// handleConsoleResize sizes the task's console to match ours. Windows has no
+// SIGWINCH, so later changes to our console size are not followed.
+func handleConsoleResize(ctx context.Context, task resizer, con console.Console) (func(), error) {
+	size, err := con.Size()
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Resize(ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+		return nil, err
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "start a container that has been created",
+	ArgsUsage: "CONTAINER",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "detach,d",
+			Usage: "detach from the task after it has started execution, logging its output",
+		},
+		logURIFlag,
+		cli.BoolFlag{
+			Name:  "interactive,i",
+			Usage: "attach our stdin to the container",
+		},
+		detachKeysFlag,
+	},
+	Action: func(clicontext *cli.Context) error {
+		name := clicontext.Args().First()
+		if name == "" {
+			return errors.New("container name must be provided")
+		}
+		detachKeys, err := parseDetachKeys(clicontext.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		container, err := c.client.LoadContainer(c.ctx, name)
+		if err != nil {
+			return err
+		}
+		if err := c.loadSpec(container); err != nil {
+			return err
+		}
+		c.stdin = clicontext.Bool("interactive")
+		c.logURI = clicontext.String("log-uri")
+		c.detachKeys = detachKeys
+		var sigc chan os.Signal
+		if !clicontext.Bool("detach") {
+			sigc = catchSignals()
+			defer stopCatch(sigc)
+		}
+		exitStatus, err := c.startContainer(container, sigc)
+		if err == errDetached {
+			log.Infof("detached from container %s", name)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if code := exitStatus.ExitCode(); code != 0 {
+			return cli.NewExitError("", int(code))
+		}
+		return nil
+	},
+}
+
+var stopCommand = cli.Command{
+	Name:      "stop",
+	Usage:     "stop the task of one or more containers",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "time,t",
+			Usage: "grace period after the stop signal before the task is killed",
+			Value: defaultStopTimeout,
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("at least one container name must be provided")
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var exitErr error
+		for _, name := range clicontext.Args() {
+			if err := c.stopContainer(name, clicontext.Duration("time")); err != nil {
+				log.Errorf("failed to stop container %s: %v", name, err)
+				exitErr = errors.New("failed to stop one or more containers")
+				continue
+			}
+			fmt.Println(name)
+		}
+		return exitErr
+	},
+}
+
+var rmCommand = cli.Command{
+	Name:      "rm",
+	Usage:     "remove one or more containers and their snapshots",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force,f",
+			Usage: "kill the container's task before removing it",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("at least one container name must be provided")
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var exitErr error
+		for _, name := range clicontext.Args() {
+			if clicontext.Bool("force") {
+				if err := c.stopContainer(name, 0); err != nil {
+					log.Errorf("failed to stop container %s: %v", name, err)
+					exitErr = errors.New("failed to remove one or more containers")
+					continue
+				}
+			}
+			if err := c.deleteContainer(name); err != nil {
+				log.Errorf("failed to remove container %s: %v", name, err)
+				exitErr = errors.New("failed to remove one or more containers")
+				continue
+			}
+			fmt.Println(name)
+		}
+		return exitErr
+	},
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/plugin"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/urfave/cli"
+)
+
+// status of a doctor check
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+// checkResult is the outcome of a doctor check, with the fix to suggest if it
+// did not pass
+type checkResult struct {
+	name   string
+	status string
+	detail string
+	fix    string
+}
+
+var doctorCommand = cli.Command{
+	Name:      "doctor",
+	Usage:     "check that the host and the containerd daemon can run containers, suggesting fixes",
+	ArgsUsage: "[USER[:GROUP]]",
+	Description: `Checks user namespace support, the cgroup setup, the subordinate ID
+   ranges of USER[:GROUP] (the current user by default), the daemon socket and
+   the plugins and snapshotters loaded by the daemon.`,
+	Action: func(clicontext *cli.Context) error {
+		var results []checkResult
+		results = append(results, checkUserNamespaces()...)
+		results = append(results, checkCgroups()...)
+		results = append(results, checkSubIDs(clicontext.Args().First())...)
+		results = append(results, checkDaemon(clicontext)...)
+
+		w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tSTATUS\tDETAILS")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.name, r.status, r.detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		failed := false
+		for _, r := range results {
+			if r.status == checkFailed {
+				failed = true
+			}
+			if r.fix != "" && (r.status == checkFailed || r.status == checkWarning) {
+				fmt.Printf("\n%s: %s\n", r.name, r.fix)
+			}
+		}
+		if failed {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	},
+}
+
+// checkSubIDs checks that a user and group, the current user by default, have
+// valid subordinate ID ranges which do not overlap the ranges of others.
+// Missing ranges of the current user only warrant a warning, as they are not
+// needed unless containers are run with --userns.
+func checkSubIDs(userGroup string) []checkResult {
+	missing := checkFailed
+	if userGroup == "" {
+		missing = checkWarning
+		usr, err := idtools.LookupUID(os.Getuid())
+		if err != nil {
+			return []checkResult{{
+				name:   "subordinate IDs",
+				status: checkFailed,
+				detail: fmt.Sprintf("cannot look up the current user: %v", err),
+				fix:    "pass the user to check as USER[:GROUP]",
+			}}
+		}
+		userGroup = usr.Name
+	}
+	username, groupname, err := parseUserGroup(userGroup)
+	if err != nil {
+		return []checkResult{{name: "subordinate IDs", status: checkFailed, detail: err.Error()}}
+	}
+	name := fmt.Sprintf("subordinate IDs (%s:%s)", username, groupname)
+	idMappings, err := idtools.NewIDMappings(username, groupname)
+	if err != nil {
+		return []checkResult{{
+			name:   name,
+			status: missing,
+			detail: err.Error(),
+			fix: fmt.Sprintf("give %s ranges with `examplectr userns add %s`, or use --uidmap/--gidmap",
+				username, username),
+		}}
+	}
+	subuid, subgid, err := idtools.ListSubordinateRanges()
+	if err != nil {
+		return []checkResult{{name: name, status: checkFailed, detail: err.Error()}}
+	}
+	for _, idMap := range [][]idtools.IDMap{idMappings.UIDs(), idMappings.GIDs()} {
+		if err := idtools.ValidateIDMap(idMap); err != nil {
+			return []checkResult{{
+				name:   name,
+				status: checkFailed,
+				detail: err.Error(),
+				fix:    fmt.Sprintf("fix the entries of %s in %s and %s", username, subuid.Path, subgid.Path),
+			}}
+		}
+	}
+
+	var overlaps []string
+	for _, f := range []struct {
+		idtools.SubIDFile
+		idMap []idtools.IDMap
+	}{{subuid, idMappings.UIDs()}, {subgid, idMappings.GIDs()}} {
+		for _, m := range f.idMap {
+			// every mapping comes from one range of its own, which any
+			// other range may not overlap
+			own := false
+			for _, r := range f.Ranges {
+				if !own && r.Start == m.HostID && r.Length == m.Size {
+					own = true
+					continue
+				}
+				if r.Start < m.HostID+m.Size && m.HostID < r.Start+r.Length {
+					overlaps = append(overlaps, fmt.Sprintf("%s in %s", r, f.Path))
+				}
+			}
+		}
+	}
+	uid, gid, _ := idtools.GetRootUIDGID(idMappings.UIDs(), idMappings.GIDs())
+	result := checkResult{
+		name:   name,
+		status: checkOK,
+		detail: fmt.Sprintf("container root maps to %d:%d", uid, gid),
+	}
+	if len(overlaps) > 0 {
+		result.status = checkWarning
+		result.detail = "ranges overlap " + strings.Join(overlaps, ", ")
+		result.fix = "containers of different users share host IDs; move the overlapping ranges apart, see `examplectr userns ls`"
+	}
+	return []checkResult{result}
+}
+
+// checkDaemon checks that the daemon socket is reachable and that the daemon
+// loaded the plugins examplectr relies on
+func checkDaemon(clicontext *cli.Context) []checkResult {
+	cfg, err := loadConfig(clicontext)
+	if err != nil {
+		return []checkResult{{name: "daemon socket", status: checkFailed, detail: err.Error(),
+			fix: "fix the config file given with --config"}}
+	}
+	socket := checkResult{name: "daemon socket", status: checkOK, detail: cfg.Address}
+	if fi, err := os.Stat(cfg.Address); err != nil {
+		socket.status, socket.detail = checkFailed, err.Error()
+		socket.fix = "start containerd, or point --address (CONTAINERD_ADDRESS) at its socket"
+		if os.IsPermission(err) {
+			socket.fix = "run examplectr as root or as a user with access to the socket"
+		}
+	} else if fi.Mode()&os.ModeSocket == 0 {
+		socket.status, socket.detail = checkFailed, cfg.Address+" is not a socket"
+		socket.fix = "point --address (CONTAINERD_ADDRESS) at containerd's socket"
+	}
+	skipped := []checkResult{
+		{name: "plugins", status: checkSkipped, detail: "daemon not reachable"},
+		{name: "snapshotter", status: checkSkipped, detail: "daemon not reachable"},
+	}
+	if socket.status != checkOK {
+		return append([]checkResult{socket}, skipped...)
+	}
+
+	c, err := newCC(clicontext)
+	if err != nil {
+		socket.status, socket.detail = checkFailed, err.Error()
+		socket.fix = "make sure containerd is running and serving on the socket; run as root if access is denied"
+		return append([]checkResult{socket}, skipped...)
+	}
+	defer c.close()
+	v, err := c.client.Version(c.ctx)
+	if err != nil {
+		socket.status, socket.detail = checkFailed, err.Error()
+		socket.fix = "make sure containerd is running and serving on the socket"
+		return append([]checkResult{socket}, skipped...)
+	}
+	socket.detail = fmt.Sprintf("%s, containerd %s", cfg.Address, v.Version)
+
+	resp, err := c.client.IntrospectionService().Plugins(c.ctx, nil)
+	if err != nil {
+		return []checkResult{socket, {name: "plugins", status: checkFailed, detail: err.Error(),
+			fix: "upgrade containerd to a version with the introspection service"}}
+	}
+	plugins := checkResult{name: "plugins", status: checkOK}
+	var (
+		loaded, failed []string
+		snapshotters   = map[string]string{}
+		runtime        bool
+	)
+	for _, p := range resp.Plugins {
+		if p.Type == plugin.SnapshotPlugin.String() {
+			snapshotters[p.ID] = ""
+			if p.InitErr != nil {
+				snapshotters[p.ID] = p.InitErr.Message
+			}
+		}
+		if p.InitErr != nil {
+			failed = append(failed, fmt.Sprintf("%s.%s (%s)", p.Type, p.ID, p.InitErr.Message))
+			continue
+		}
+		if p.Type == plugin.RuntimePluginV2.String() && p.ID == "task" {
+			runtime = true
+		}
+		loaded = append(loaded, p.ID)
+	}
+	plugins.detail = fmt.Sprintf("%d loaded", len(loaded))
+	switch {
+	case !runtime:
+		plugins.status = checkFailed
+		plugins.detail = "the runtime v2 task plugin is not loaded"
+		plugins.fix = "run containerd 1.2 or later with the io.containerd.runtime.v2.task plugin enabled"
+	case len(failed) > 0:
+		plugins.status = checkWarning
+		plugins.detail = fmt.Sprintf("%d loaded, %d failed: %s", len(loaded), len(failed), strings.Join(failed, ", "))
+		plugins.fix = "the failed plugins are unusable; check the containerd logs for why they did not load"
+	}
+
+	snapshotter := checkResult{name: "snapshotter", status: checkOK}
+	var available []string
+	for id, initErr := range snapshotters {
+		if initErr == "" {
+			available = append(available, id)
+		}
+	}
+	sort.Strings(available)
+	snapshotter.detail = fmt.Sprintf("%s (available: %s)", containerd.DefaultSnapshotter, strings.Join(available, ", "))
+	initErr, ok := snapshotters[containerd.DefaultSnapshotter]
+	switch {
+	case !ok:
+		snapshotter.status = checkFailed
+		snapshotter.detail = fmt.Sprintf("%s is not registered; available: %s", containerd.DefaultSnapshotter, strings.Join(available, ", "))
+		snapshotter.fix = fmt.Sprintf("enable the %s snapshotter in the containerd config", containerd.DefaultSnapshotter)
+	case initErr != "":
+		snapshotter.status = checkFailed
+		snapshotter.detail = fmt.Sprintf("%s failed to load: %s", containerd.DefaultSnapshotter, initErr)
+		snapshotter.fix = "load the overlay kernel module (modprobe overlay) and put containerd's root on a filesystem overlayfs supports, such as ext4 or xfs"
+	}
+	return []checkResult{socket, plugins, snapshotter}
+}
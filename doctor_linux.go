@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/estesp/examplectr/idtools"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxUserNamespacesPath = "/proc/sys/user/max_user_namespaces"
+	cgroupRoot            = "/sys/fs/cgroup"
+)
+
+// checkUserNamespaces checks that the kernel lets containers have user
+// namespaces, and whether examplectr itself runs in one
+func checkUserNamespaces() []checkResult {
+	result := checkResult{name: "user namespaces", status: checkOK}
+	data, err := ioutil.ReadFile(maxUserNamespacesPath)
+	switch {
+	case os.IsNotExist(err):
+		result.status = checkFailed
+		result.detail = maxUserNamespacesPath + " does not exist"
+		result.fix = "use a kernel built with CONFIG_USER_NS=y to run containers with --userns"
+	case err != nil:
+		result.status, result.detail = checkFailed, err.Error()
+	default:
+		max, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		switch {
+		case err != nil:
+			result.status = checkFailed
+			result.detail = fmt.Sprintf("cannot parse %s: %v", maxUserNamespacesPath, err)
+		case max == 0:
+			result.status = checkFailed
+			result.detail = "user.max_user_namespaces is 0"
+			result.fix = "enable user namespaces with `sysctl -w user.max_user_namespaces=15000`, and in /etc/sysctl.d to keep it across reboots"
+		default:
+			result.detail = fmt.Sprintf("at most %d", max)
+		}
+	}
+	results := []checkResult{result}
+
+	if idtools.RunningInUserNS() {
+		results = append(results, checkResult{
+			name:   "host user namespace",
+			status: checkWarning,
+			detail: "examplectr runs inside a user namespace",
+			fix:    "ID mappings must lie within the IDs mapped into examplectr's own namespace (see /proc/self/uid_map)",
+		})
+	}
+	return results
+}
+
+// checkCgroups reports the cgroup mode and checks that the hierarchies
+// containers are placed in are mounted read-write
+func checkCgroups() []checkResult {
+	result := checkResult{name: "cgroups", status: checkOK}
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		result.status, result.detail = checkFailed, err.Error()
+		result.fix = "mount the cgroup filesystems under " + cgroupRoot
+		return []checkResult{result}
+	}
+
+	var mounts []string
+	switch st.Type {
+	case unix.CGROUP2_SUPER_MAGIC:
+		result.detail = "v2 (unified)"
+		mounts = []string{cgroupRoot}
+	case unix.TMPFS_MAGIC:
+		result.detail = "v1"
+		if _, err := os.Stat(filepath.Join(cgroupRoot, "unified", "cgroup.controllers")); err == nil {
+			result.detail = "v1 (hybrid, with a v2 hierarchy at " + filepath.Join(cgroupRoot, "unified") + ")"
+		}
+		dirs, err := ioutil.ReadDir(cgroupRoot)
+		if err != nil {
+			result.status, result.detail = checkFailed, err.Error()
+			return []checkResult{result}
+		}
+		for _, d := range dirs {
+			if d.IsDir() {
+				mounts = append(mounts, filepath.Join(cgroupRoot, d.Name()))
+			}
+		}
+		if len(mounts) == 0 {
+			result.status = checkFailed
+			result.detail = "no cgroup v1 hierarchies are mounted under " + cgroupRoot
+			result.fix = "mount the cgroup v1 controllers under " + cgroupRoot + ", or boot with the unified (v2) hierarchy"
+			return []checkResult{result}
+		}
+	default:
+		result.status = checkFailed
+		result.detail = fmt.Sprintf("%s is not a cgroup or tmpfs mount", cgroupRoot)
+		result.fix = "mount the cgroup filesystems under " + cgroupRoot
+		return []checkResult{result}
+	}
+
+	var readOnly []string
+	for _, m := range mounts {
+		var st unix.Statfs_t
+		if err := unix.Statfs(m, &st); err != nil {
+			continue
+		}
+		if st.Flags&unix.ST_RDONLY != 0 {
+			readOnly = append(readOnly, m)
+		}
+	}
+	if len(readOnly) > 0 {
+		result.status = checkFailed
+		result.detail += "; mounted read-only: " + strings.Join(readOnly, ", ")
+		result.fix = "remount the cgroup hierarchies read-write, for example with `mount -o remount,rw " +
+			readOnly[0] + "` for each (linuxkit's mount-cgroups-rw.sh does this)"
+	}
+	return []checkResult{result}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package main
+
+// checkUserNamespaces is skipped where there are no user namespaces
+func checkUserNamespaces() []checkResult {
+	return []checkResult{{name: "user namespaces", status: checkSkipped, detail: "not supported on this OS"}}
+}
+
+// checkCgroups is skipped where there are no cgroups
+func checkCgroups() []checkResult {
+	return []checkResult{{name: "cgroups", status: checkSkipped, detail: "not supported on this OS"}}
+}
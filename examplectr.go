@@ -3,96 +3,401 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"strings"
 
+	"github.com/containerd/console"
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/typeurl"
+	"github.com/estesp/examplectr/idtools"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	defaultContainerdPath = "/run/containerd/containerd.sock"
-	defaultImage          = "docker.io/library/alpine:latest"
-)
-
-func main() {
+// simple client object for executing containers
+type cc struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	client     *containerd.Client
+	image      string
+	name       string
+	args       []string
+	specOpts   []oci.SpecOpts
+	tty        bool
+	stdin      bool
+	remove     bool
+	detach     bool
+	logURI     string
+	logDir     string
+	stateDir   string
+	detachKeys []byte
+	detached   chan struct{}
+	idMappings *idtools.IDMappings
+	// the --userns USER:GROUP whose subordinate ranges idMappings are
+	usernsUser string
+	// how the rootfs is remapped for idMappings: auto, idmap or chown
+	remapRootfs string
+	// set to give the container its own slice of a pool's ID ranges
+	idAllocator *idtools.RangeAllocator
+}
 
-	var (
-		imageName string
-		command   string
-	)
+func (c *cc) runContainer() (_ containerd.ExitStatus, err error) {
+	// let's get an image
+	image, err := c.getImage()
+	if err != nil {
+		return containerd.ExitStatus{}, err
+	}
 
-	switch len(os.Args) {
-	case 2:
-		imageName = os.Args[1]
-	case 3:
-		imageName = os.Args[1]
-		command = os.Args[2]
-	default:
-		imageName = defaultImage
+	// unless asked to detach, if there is a command or we are attached
+	// interactively, we stay in the foreground and catch signals before the
+	// container exists, so that they can't kill the client and leave the
+	// container behind
+	var sigc chan os.Signal
+	if !c.detach && (len(c.args) > 0 || c.tty || c.stdin || c.remove) {
+		sigc = catchSignals()
+		defer stopCatch(sigc)
 	}
 
-	// connect to containerd daemon over UNIX socket
-	client, err := containerd.New(defaultContainerdPath)
+	// create a container
+	container, err := c.newContainer(image)
 	if err != nil {
-		log.Errorf("error connecting to containerd daemon: %v", err)
-		return
+		return containerd.ExitStatus{}, errors.Wrap(err, "error creating container")
+	}
+	if c.remove {
+		defer func() {
+			if err == errDetached {
+				log.Warnf("container %s is not removed after detaching from it", container.ID())
+				return
+			}
+			if err := c.removeContainer(c.cleanupContext(), container); err != nil {
+				log.Errorf("error removing container %s: %v", container.ID(), err)
+			}
+		}()
+	}
+	return c.startContainer(container, sigc)
+}
+
+// startContainer creates and starts the task for a container. If sigc is nil
+// the task is left running detached from the client with its output logged to
+// a file; otherwise the signals caught on sigc are forwarded to the task,
+// which is waited on and deleted once it exits, unless the client is detached
+// from it with the detach keys.
+func (c *cc) startContainer(container containerd.Container, sigc chan os.Signal) (_ containerd.ExitStatus, err error) {
+	detach := sigc == nil
+
+	// with a TTY our terminal becomes the container's console; it is put
+	// into raw mode while we are attached and restored on every return
+	var con console.Console
+	if c.tty {
+		if detach {
+			return containerd.ExitStatus{}, errors.New("a container with a TTY cannot be started detached")
+		}
+		if con, err = currentConsole(); err != nil {
+			return containerd.ExitStatus{}, err
+		}
+		defer con.Reset()
+		if err := con.SetRaw(); err != nil {
+			return containerd.ExitStatus{}, errors.Wrap(err, "error setting terminal to raw mode")
+		}
 	}
 
-	// set up a namespace
-	ctx := namespaces.WithNamespace(context.Background(), "examplectr")
+	if err := c.remountIDMappedRootfs(c.ctx, container); err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error mounting ID-mapped rootfs")
+	}
 
-	version, err := client.Version(ctx)
+	// create a task
+	task, err := c.newTask(container, con, detach)
 	if err != nil {
-		log.Errorf("error retrieving containerd version: %v", err)
-		return
+		return containerd.ExitStatus{}, errors.Wrap(err, "error creating task")
+	}
+	if !detach {
+		// the task is killed if needed and deleted even when waiting on it
+		// failed or the client's context has been canceled
+		defer func() {
+			if err == errDetached {
+				return
+			}
+			if _, err := task.Delete(c.cleanupContext(), containerd.WithProcessKill); err != nil {
+				log.Errorf("error deleting task: %v", err)
+			}
+		}()
 	}
-	fmt.Printf("containerd version (daemon: %s [Revision: %s])\n", version.Version, version.Revision)
 
-	// let's get an image
-	image, err := client.GetImage(ctx, imageName)
+	// if we are not detaching, then wait on the task
+	var statusC <-chan containerd.ExitStatus
+	if !detach {
+		statusC, err = task.Wait(c.ctx)
+		if err != nil {
+			return containerd.ExitStatus{}, errors.Wrap(err, "error waiting on task")
+		}
+	}
+
+	// start the task
+	if err := task.Start(c.ctx); err != nil {
+		if detach {
+			task.Delete(c.cleanupContext())
+		}
+		return containerd.ExitStatus{}, errors.Wrap(err, "error starting task")
+	}
+
+	// make sure the kernel gave the task the user namespace we asked for
+	if c.idMappings != nil {
+		if err := c.verifyIDMappings(task.Pid()); err != nil {
+			if detach {
+				task.Delete(c.cleanupContext(), containerd.WithProcessKill)
+			}
+			return containerd.ExitStatus{}, err
+		}
+	}
+
+	if !detach {
+		return c.waitAttached(task, con, statusC, sigc)
+	}
+	return containerd.ExitStatus{}, nil
+}
+
+// cleanupContext returns a context in the client's namespace which is not
+// canceled along with the client's context, so cleanup still happens after
+// a command timeout
+func (c *cc) cleanupContext() context.Context {
+	ns, _ := namespaces.Namespace(c.ctx)
+	return namespaces.WithNamespace(context.Background(), ns)
+}
+
+// getImage returns the client's image, pulling it if it isn't already
+// available in our namespace
+func (c *cc) getImage() (containerd.Image, error) {
+	image, err := c.client.GetImage(c.ctx, c.image)
 	if err != nil {
 		// if the image isn't already in our namespaced context, then pull it
-		image, err = client.Pull(ctx, imageName, containerd.WithPullUnpack)
+		image, err = c.client.Pull(c.ctx, c.image, containerd.WithPullUnpack)
 		if err != nil {
-			// error pulling the image
-			log.Errorf("couldn't pull image %s: %v", imageName, err)
-			return
+			return nil, errors.Wrapf(err, "couldn't pull image %s", c.image)
 		}
 	}
+	return image, nil
+}
 
-	// create a container
-	var container containerd.Container
-	if command != "" {
-		// the command needs to be overridden in the generated spec
-		container, err = client.NewContainer(ctx, "exampleCtr",
-			containerd.WithNewSnapshot("exampleCtr", image),
-			containerd.WithNewSpec(oci.WithImageConfig(image),
-				oci.WithProcessArgs(strings.Split(command, " ")...)))
+func (c *cc) newContainer(image containerd.Image) (_ containerd.Container, err error) {
+	ctx := c.ctx
+	newOpts := []containerd.NewContainerOpts{
+		containerd.WithImageStopSignal(image, defaultStopSignal),
+	}
+	if c.idAllocator != nil {
+		if c.idMappings, err = c.allocateIDMappings(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				c.releaseIDMappings(c.name)
+			}
+		}()
+		newOpts = append(newOpts, withLabel(usernsAllocatedLabel, c.idAllocator.User+":"+c.idAllocator.Group))
+	}
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+	}
+	if len(c.args) > 0 {
+		specOpts = append(specOpts, oci.WithProcessArgs(c.args...))
+	}
+	if c.tty {
+		specOpts = append(specOpts, oci.WithTTY)
+	}
+	specOpts = append(specOpts, c.specOpts...)
+
+	if c.idMappings != nil {
+		if err := idtools.ValidateIDMap(c.idMappings.UIDs()); err != nil {
+			return nil, errors.Wrap(err, "invalid UID mappings")
+		}
+		if err := idtools.ValidateIDMap(c.idMappings.GIDs()); err != nil {
+			return nil, errors.Wrap(err, "invalid GID mappings")
+		}
+		// the remapped root owns the snapshot and the task's IO, so container
+		// root must be mapped by both the UID and the GID maps
+		uid, gid, err := idtools.GetRootUIDGID(c.idMappings.UIDs(), c.idMappings.GIDs())
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid user namespace mappings")
+		}
+		// use user namespaces for this container
+		specOpts = append(specOpts, oci.WithUserNamespace(convertToOCI(c.idMappings.UIDs()),
+			convertToOCI(c.idMappings.GIDs())))
+		newOpts = append(newOpts, withRuntimeOptions(&options.Options{
+			IoUid: uint32(uid),
+			IoGid: uint32(gid),
+		}))
+
+		// the container's rootfs is the unmodified image snapshot seen
+		// through an ID-mapped mount where the kernel supports it, and a
+		// remapped copy of the image otherwise
+		var rootfs string
+		if c.remapRootfs != remapRootfsChown {
+			// the snapshot is only referenced by the container once it
+			// exists, so it is kept from garbage collection by a lease
+			var done func(context.Context) error
+			if ctx, done, err = c.client.WithLease(ctx); err != nil {
+				return nil, err
+			}
+			defer done(c.cleanupContext())
+
+			rootfs, err = c.mountIDMappedRootfs(ctx, image)
+			switch {
+			case err == nil:
+				defer func() {
+					if err != nil {
+						c.unmountIDMappedRootfs(c.cleanupContext(), c.name, c.name)
+					}
+				}()
+				// first, as the image's user is looked up in the rootfs
+				specOpts = append([]oci.SpecOpts{oci.WithRootFSPath(rootfs)}, specOpts...)
+				newOpts = append(newOpts, withIDMappedRootfs(c.name, image))
+			case errors.Cause(err) == errIDMapUnsupported && c.remapRootfs == remapRootfsAuto:
+				log.Debugf("%v; using a remapped copy of the image", err)
+			default:
+				return nil, errors.Wrap(err, "error mounting ID-mapped rootfs")
+			}
+		}
+		if rootfs == "" {
+			newOpts = append(newOpts, c.withRemappedBaseSnapshot(c.name, image))
+		}
+	} else {
+		newOpts = append(newOpts, containerd.WithNewSnapshot(c.name, image))
+	}
+	newOpts = append(newOpts, containerd.WithNewSpec(specOpts...))
+
+	return c.client.NewContainer(ctx, c.name, newOpts...)
+}
+
+// newTask creates a task for the container with IO attached to our stdio, or
+// to the console if one is given, or logged to a file if detached. The task
+// is created with the container's runtime options, so in a user namespace
+// its IO pipes are owned by the remapped root.
+func (c *cc) newTask(container containerd.Container, con console.Console, detach bool) (containerd.Task, error) {
+	var (
+		ioCreator cio.Creator
+		stdinC    *stdinCloser
+		err       error
+	)
+	if detach {
+		if ioCreator, err = c.logIO(container); err != nil {
+			return nil, err
+		}
 	} else {
-		container, err = client.NewContainer(ctx, "exampleCtr",
-			containerd.WithNewSpec(oci.WithImageConfig(image)),
-			containerd.WithNewSnapshot("exampleCtr", image))
+		ioCreator, stdinC = c.stdio(con)
 	}
+
+	task, err := container.NewTask(c.ctx, ioCreator)
 	if err != nil {
-		log.Errorf("error creating container: %v", err)
-		return
+		return nil, err
+	}
+	if stdinC != nil {
+		stdinC.closer = func() {
+			task.CloseIO(c.ctx, containerd.WithStdinCloser)
+		}
 	}
+	return task, nil
+}
 
-	// create a task
-	task, err := container.NewTask(ctx, cio.NewCreator(cio.WithStdio))
+// withRuntimeOptions sets the options of the container's runtime, which the
+// shim also uses for the container's tasks when they are created without
+// options of their own
+func withRuntimeOptions(opts interface{}) containerd.NewContainerOpts {
+	return func(_ context.Context, _ *containerd.Client, c *containers.Container) error {
+		any, err := typeurl.MarshalAny(opts)
+		if err != nil {
+			return err
+		}
+		c.Runtime.Options = any
+		return nil
+	}
+}
+
+// withLabel adds a label to the container
+func withLabel(key, value string) containerd.NewContainerOpts {
+	return func(_ context.Context, _ *containerd.Client, c *containers.Container) error {
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+		c.Labels[key] = value
+		return nil
+	}
+}
+
+// stdio returns the IO creator attaching a process to our stdio, or to the
+// console if one is given. Stdin is only attached when the client is
+// interactive, and reading the detach keys from it detaches the client. The
+// returned stdinCloser, if any, must be given the function closing the
+// process's stdin.
+func (c *cc) stdio(con console.Console) (cio.Creator, *stdinCloser) {
+	switch {
+	case con != nil:
+		var stdin io.Reader
+		if c.stdin {
+			stdin = c.detachable(con)
+		}
+		return cio.NewCreator(cio.WithStreams(stdin, con, nil), cio.WithTerminal), nil
+	case c.stdin:
+		stdinC := &stdinCloser{stdin: c.detachable(os.Stdin)}
+		return cio.NewCreator(cio.WithStreams(stdinC, os.Stdout, os.Stderr)), stdinC
+	default:
+		return cio.NewCreator(cio.WithStreams(nil, os.Stdout, os.Stderr)), nil
+	}
+}
+
+// loadSpec sets the client's TTY mode and ID mappings from the spec of an
+// existing container
+func (c *cc) loadSpec(container containerd.Container) error {
+	spec, err := container.Spec(c.ctx)
 	if err != nil {
-		log.Errorf("error creating task: %v", err)
-		return
+		return errors.Wrapf(err, "error reading spec for container %s", container.ID())
+	}
+	c.tty = spec.Process != nil && spec.Process.Terminal
+	if spec.Linux == nil || len(spec.Linux.UIDMappings) == 0 {
+		c.idMappings = nil
+		return nil
 	}
+	c.idMappings = idtools.NewIDMappingsFromMaps(convertFromOCI(spec.Linux.UIDMappings),
+		convertFromOCI(spec.Linux.GIDMappings))
+	return nil
+}
 
-	// start the task
-	if err := task.Start(ctx); err != nil {
-		task.Delete(ctx)
-		log.Errorf("error starting task: %v", err)
+// convertToOCI converts ID mappings to their OCI form; they must have been
+// checked with idtools.ValidateIDMap so that the IDs fit in 32 bits
+func convertToOCI(idMap []idtools.IDMap) []rspec.LinuxIDMapping {
+	idMaps := make([]rspec.LinuxIDMapping, len(idMap))
+	for i, im := range idMap {
+		newMap := rspec.LinuxIDMapping{
+			ContainerID: uint32(im.ContainerID),
+			HostID:      uint32(im.HostID),
+			Size:        uint32(im.Size),
+		}
+		idMaps[i] = newMap
+	}
+	return idMaps
+}
+
+func convertFromOCI(idMap []rspec.LinuxIDMapping) []idtools.IDMap {
+	idMaps := make([]idtools.IDMap, len(idMap))
+	for i, im := range idMap {
+		idMaps[i] = idtools.IDMap{
+			ContainerID: int(im.ContainerID),
+			HostID:      int(im.HostID),
+			Size:        int(im.Size),
+		}
+	}
+	return idMaps
+}
+
+func (c *cc) printVersion() {
+	version, err := c.client.Version(c.ctx)
+	if err != nil {
+		log.Errorf("error retrieving containerd version: %v", err)
 		return
 	}
+	fmt.Printf("containerd version (daemon: %s [Revision: %s])\n", version.Version, version.Revision)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/oci"
+	"github.com/estesp/examplectr/idtools"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var execCommand = cli.Command{
+	Name:      "exec",
+	Usage:     "run an additional process in a container's running task",
+	ArgsUsage: "CONTAINER [--] COMMAND [ARG...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "exec-id",
+			Usage: "ID of the exec process (default: a random ID)",
+		},
+		cli.BoolFlag{
+			Name:  "tty,t",
+			Usage: "allocate a TTY for the process",
+		},
+		cli.BoolFlag{
+			Name:  "interactive,i",
+			Usage: "attach our stdin to the process",
+		},
+		detachKeysFlag,
+		cli.StringFlag{
+			Name:  "workdir,w",
+			Usage: "working directory of the process",
+		},
+		cli.StringSliceFlag{
+			Name:  "env,e",
+			Usage: "set an environment variable (`KEY=VALUE`), or unset it when no value is given",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment variables from a file of KEY=VALUE lines",
+		},
+		cli.StringFlag{
+			Name:  "user,u",
+			Usage: "user to run the process as (`USER[:GROUP]` or UID[:GID])",
+		},
+	},
+	// everything after the container is the process's argv
+	SkipArgReorder: true,
+	Action: func(clicontext *cli.Context) error {
+		name := clicontext.Args().First()
+		if name == "" {
+			return errors.New("container name must be provided")
+		}
+		args := clicontext.Args().Tail()
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return errors.New("command must be provided")
+		}
+		detachKeys, err := parseDetachKeys(clicontext.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		execID := clicontext.String("exec-id")
+		if execID == "" {
+			if execID, err = newExecID(); err != nil {
+				return err
+			}
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		container, err := c.client.LoadContainer(c.ctx, name)
+		if err != nil {
+			return err
+		}
+		// the ID mappings come from the container, the TTY mode is our own
+		if err := c.loadSpec(container); err != nil {
+			return err
+		}
+		c.args = args
+		c.tty = clicontext.Bool("tty")
+		c.stdin = clicontext.Bool("interactive")
+		c.detachKeys = detachKeys
+		if err := c.setProcessOpts(clicontext); err != nil {
+			return err
+		}
+
+		sigc := catchSignals()
+		defer stopCatch(sigc)
+		exitStatus, err := c.execProcess(container, execID, sigc)
+		if err == errDetached {
+			log.Infof("detached from exec process %s", execID)
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to exec in container %s", name)
+		}
+		if exitStatus.Error() != nil {
+			log.Errorf("process exited with error: %v", exitStatus.Error())
+		}
+		if code := exitStatus.ExitCode(); code != 0 {
+			return cli.NewExitError("", int(code))
+		}
+		return nil
+	},
+}
+
+// execProcess runs the client's args as an additional process in the
+// container's running task, forwarding the signals caught on sigc to it. The
+// process is waited on and deleted once it exits, unless the client is
+// detached from it with the detach keys.
+func (c *cc) execProcess(container containerd.Container, execID string, sigc chan os.Signal) (_ containerd.ExitStatus, err error) {
+	task, err := container.Task(c.ctx, nil)
+	if err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error loading task")
+	}
+	spec, err := c.processSpec(container)
+	if err != nil {
+		return containerd.ExitStatus{}, err
+	}
+
+	var con console.Console
+	if c.tty {
+		if con, err = currentConsole(); err != nil {
+			return containerd.ExitStatus{}, err
+		}
+		defer con.Reset()
+		if err := con.SetRaw(); err != nil {
+			return containerd.ExitStatus{}, errors.Wrap(err, "error setting terminal to raw mode")
+		}
+	}
+
+	// the shim creates the IO pipes of exec processes with the owner given
+	// in the container's runtime options, which is the remapped root for
+	// containers created with user namespaces, so no options are needed here
+	ioCreator, stdinC := c.stdio(con)
+	process, err := task.Exec(c.ctx, execID, spec, ioCreator)
+	if err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error creating exec process")
+	}
+	if stdinC != nil {
+		stdinC.closer = func() {
+			process.CloseIO(c.ctx, containerd.WithStdinCloser)
+		}
+	}
+	defer func() {
+		if err == errDetached {
+			return
+		}
+		if _, err := process.Delete(c.cleanupContext(), containerd.WithProcessKill); err != nil {
+			log.Errorf("error deleting exec process: %v", err)
+		}
+	}()
+
+	statusC, err := process.Wait(c.ctx)
+	if err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error waiting on exec process")
+	}
+	if err := process.Start(c.ctx); err != nil {
+		return containerd.ExitStatus{}, errors.Wrap(err, "error starting exec process")
+	}
+	return c.waitAttached(process, con, statusC, sigc)
+}
+
+// processSpec returns the spec of an exec process: the process of the
+// container's spec with the client's args, TTY mode and process options
+// applied. In a user namespace the process's user must be mapped.
+func (c *cc) processSpec(container containerd.Container) (*rspec.Process, error) {
+	info, err := container.Info(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := container.Spec(c.ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading spec for container %s", container.ID())
+	}
+	if spec.Process == nil {
+		return nil, errors.Errorf("container %s has no process spec", container.ID())
+	}
+	spec.Process.Terminal = false
+	opts := []oci.SpecOpts{oci.WithProcessArgs(c.args...)}
+	if c.tty {
+		opts = append(opts, oci.WithTTY)
+	}
+	opts = append(opts, c.specOpts...)
+	if err := oci.ApplyOpts(c.ctx, c.client, &info, spec, opts...); err != nil {
+		return nil, errors.Wrap(err, "error generating exec process spec")
+	}
+
+	if c.idMappings != nil {
+		user := idtools.IDPair{UID: int(spec.Process.User.UID), GID: int(spec.Process.User.GID)}
+		if _, err := c.idMappings.ToHost(user); err != nil {
+			return nil, errors.Errorf("user %d:%d is not mapped in the user namespace of container %s",
+				user.UID, user.GID, container.ID())
+		}
+	}
+	return spec.Process, nil
+}
+
+// newExecID returns a random ID for an exec process
+func newExecID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "error generating exec ID")
+	}
+	return "exec-" + hex.EncodeToString(b), nil
+}
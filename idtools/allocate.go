@@ -0,0 +1,217 @@
+package idtools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSliceSize is the default number of IDs allocated to each container
+// from a pool user's subordinate ID ranges
+const DefaultSliceSize = 65536
+
+// AllocationGracePeriod is how long an allocation is kept even though its
+// owner looks stale, as its owner may still be being created
+const AllocationGracePeriod = 10 * time.Minute
+
+// RangeAllocator gives each owner, such as a container, its own slice of the
+// subordinate ID ranges of a pool user and group, so that no two owners share
+// host IDs. The slices in use are recorded in a JSON state file, which is
+// locked while it is read and updated.
+type RangeAllocator struct {
+	StatePath string
+	User      string
+	Group     string
+	Size      int
+	// Stale, if set, reports whether an owner no longer exists, such as a
+	// container removed without releasing its slice; the slices of stale
+	// owners are reclaimed once they are older than AllocationGracePeriod
+	Stale func(owner string) bool
+}
+
+// allocation holds the host ID ranges reserved for one owner
+type allocation struct {
+	UIDs      []IDMap   `json:"uids"`
+	GIDs      []IDMap   `json:"gids"`
+	Allocated time.Time `json:"allocated"`
+}
+
+// Allocate reserves the first free slice of the pool for the owner and
+// returns its mappings, each slice starting at container ID 0
+func (a *RangeAllocator) Allocate(owner string) (*IDMappings, error) {
+	if a.Size <= 0 {
+		return nil, fmt.Errorf("Invalid ID slice size %d", a.Size)
+	}
+	subuidRanges, err := parseSubuid(a.User)
+	if err != nil {
+		return nil, err
+	}
+	subgidRanges, err := parseSubgid(a.Group)
+	if err != nil {
+		return nil, err
+	}
+	if len(subuidRanges) == 0 {
+		return nil, fmt.Errorf("No subuid ranges found for user %q", a.User)
+	}
+	if len(subgidRanges) == 0 {
+		return nil, fmt.Errorf("No subgid ranges found for group %q", a.Group)
+	}
+	return a.allocateFrom(owner, createIDMap(subuidRanges), createIDMap(subgidRanges))
+}
+
+// allocateFrom reserves the first free slice of the given UID and GID pools
+// for the owner, after reclaiming the slices of stale owners
+func (a *RangeAllocator) allocateFrom(owner string, uidPool, gidPool []IDMap) (*IDMappings, error) {
+	var mappings *IDMappings
+	err := updateAllocations(a.StatePath, func(allocations map[string]allocation) error {
+		if a.Stale != nil {
+			for o, alloc := range allocations {
+				if time.Since(alloc.Allocated) > AllocationGracePeriod && a.Stale(o) {
+					delete(allocations, o)
+				}
+			}
+		}
+		if _, ok := allocations[owner]; ok {
+			return fmt.Errorf("ID ranges are already allocated to %s", owner)
+		}
+		var usedUIDs, usedGIDs []IDMap
+		for _, alloc := range allocations {
+			usedUIDs = append(usedUIDs, alloc.UIDs...)
+			usedGIDs = append(usedGIDs, alloc.GIDs...)
+		}
+		slices := mapSize(uidPool) / a.Size
+		if n := mapSize(gidPool) / a.Size; n < slices {
+			slices = n
+		}
+		for i := 0; i < slices; i++ {
+			uids := sliceIDMap(uidPool, i*a.Size, a.Size)
+			gids := sliceIDMap(gidPool, i*a.Size, a.Size)
+			if hostOverlap(uids, usedUIDs) || hostOverlap(gids, usedGIDs) {
+				continue
+			}
+			allocations[owner] = allocation{UIDs: uids, GIDs: gids, Allocated: time.Now().UTC()}
+			mappings = &IDMappings{uids: uids, gids: gids}
+			return nil
+		}
+		return fmt.Errorf("No free slice of %d IDs left in the ranges of %s:%s", a.Size, a.User, a.Group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// ReleaseRanges frees the slice allocated to the owner, if any
+func ReleaseRanges(statePath, owner string) error {
+	return updateAllocations(statePath, func(allocations map[string]allocation) error {
+		delete(allocations, owner)
+		return nil
+	})
+}
+
+// updateAllocations calls fn with the allocations recorded in the state
+// file and writes them back if fn succeeds, holding the state file's lock
+func updateAllocations(path string, fn func(map[string]allocation) error) error {
+	// the state directory is searchable, as remapped roots must be able to
+	// reach the ID-mapped rootfs mounts below it; the state file is private
+	if err := os.MkdirAll(filepath.Dir(path), 0711); err != nil {
+		return err
+	}
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("Cannot lock ID allocation state %s: %v", path, err)
+	}
+	defer unlock()
+
+	allocations := map[string]allocation{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &allocations); err != nil {
+			return fmt.Errorf("Cannot parse ID allocation state %s: %v", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := fn(allocations); err != nil {
+		return err
+	}
+	if data, err = json.MarshalIndent(allocations, "", "  "); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// writeFileAtomic replaces the file at path with data by renaming a fully
+// written temporary file over it
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// mapSize returns the number of IDs mapped by a list of mappings
+func mapSize(idMap []IDMap) int {
+	size := 0
+	for _, m := range idMap {
+		size += m.Size
+	}
+	return size
+}
+
+// sliceIDMap returns the mappings of the container IDs [start, start+size)
+// of idMap, shifted to start at container ID 0
+func sliceIDMap(idMap []IDMap, start, size int) []IDMap {
+	var slice []IDMap
+	for _, m := range idMap {
+		low, high := m.ContainerID, m.ContainerID+m.Size
+		if start > low {
+			low = start
+		}
+		if start+size < high {
+			high = start + size
+		}
+		if low >= high {
+			continue
+		}
+		slice = append(slice, IDMap{
+			ContainerID: low - start,
+			HostID:      m.HostID + low - m.ContainerID,
+			Size:        high - low,
+		})
+	}
+	return slice
+}
+
+// hostOverlap reports whether any host range of a overlaps one of b
+func hostOverlap(a, b []IDMap) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x.HostID < y.HostID+y.Size && y.HostID < x.HostID+x.Size {
+				return true
+			}
+		}
+	}
+	return false
+}
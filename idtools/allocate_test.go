@@ -0,0 +1,144 @@
+// +build !windows
+
+package idtools
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSliceIDMap(t *testing.T) {
+	pool := []IDMap{
+		{ContainerID: 0, HostID: 100000, Size: 1000},
+		{ContainerID: 1000, HostID: 300000, Size: 500},
+		{ContainerID: 1500, HostID: 500000, Size: 2000},
+	}
+	for _, tc := range []struct {
+		start, size int
+		slice       []IDMap
+	}{
+		{0, 1000, []IDMap{{ContainerID: 0, HostID: 100000, Size: 1000}}},
+		{0, 500, []IDMap{{ContainerID: 0, HostID: 100000, Size: 500}}},
+		{500, 1000, []IDMap{
+			{ContainerID: 0, HostID: 100500, Size: 500},
+			{ContainerID: 500, HostID: 300000, Size: 500},
+		}},
+		{800, 1000, []IDMap{
+			{ContainerID: 0, HostID: 100800, Size: 200},
+			{ContainerID: 200, HostID: 300000, Size: 500},
+			{ContainerID: 700, HostID: 500000, Size: 300},
+		}},
+		{3000, 500, []IDMap{{ContainerID: 0, HostID: 501500, Size: 500}}},
+		{3500, 500, nil},
+	} {
+		if slice := sliceIDMap(pool, tc.start, tc.size); !reflect.DeepEqual(slice, tc.slice) {
+			t.Errorf("sliceIDMap(%d, %d) = %v, expected %v", tc.start, tc.size, slice, tc.slice)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the UID pool spans two ranges, the GID pool is smaller
+	uidPool := []IDMap{
+		{ContainerID: 0, HostID: 100000, Size: 150},
+		{ContainerID: 150, HostID: 200000, Size: 250},
+	}
+	gidPool := []IDMap{{ContainerID: 0, HostID: 300000, Size: 300}}
+	a := &RangeAllocator{StatePath: filepath.Join(dir, "userns.json"), User: "bob", Group: "bob", Size: 100}
+
+	for _, tc := range []struct {
+		owner string
+		uids  []IDMap
+		gids  []IDMap
+	}{
+		{"ns/a", []IDMap{{ContainerID: 0, HostID: 100000, Size: 100}},
+			[]IDMap{{ContainerID: 0, HostID: 300000, Size: 100}}},
+		{"ns/b", []IDMap{{ContainerID: 0, HostID: 100100, Size: 50}, {ContainerID: 50, HostID: 200000, Size: 50}},
+			[]IDMap{{ContainerID: 0, HostID: 300100, Size: 100}}},
+		{"ns/c", []IDMap{{ContainerID: 0, HostID: 200050, Size: 100}},
+			[]IDMap{{ContainerID: 0, HostID: 300200, Size: 100}}},
+	} {
+		m, err := a.allocateFrom(tc.owner, uidPool, gidPool)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.owner, err)
+		}
+		if !reflect.DeepEqual(m.UIDs(), tc.uids) || !reflect.DeepEqual(m.GIDs(), tc.gids) {
+			t.Errorf("%s: got %v and %v, expected %v and %v", tc.owner, m.UIDs(), m.GIDs(), tc.uids, tc.gids)
+		}
+	}
+
+	// the GID pool is exhausted although UIDs are left
+	if _, err := a.allocateFrom("ns/d", uidPool, gidPool); err == nil {
+		t.Error("expected an error allocating from an exhausted pool")
+	}
+	if _, err := a.allocateFrom("ns/a", uidPool, gidPool); err == nil {
+		t.Error("expected an error allocating to an owner twice")
+	}
+
+	// a released slice is reused by the next allocation
+	if err := ReleaseRanges(a.StatePath, "ns/b"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := a.allocateFrom("ns/d", uidPool, gidPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GIDs()[0].HostID != 300100 {
+		t.Errorf("expected the released slice to be reused, got %v", m.GIDs())
+	}
+	if err := ReleaseRanges(a.StatePath, "ns/missing"); err != nil {
+		t.Errorf("releasing an unknown owner: %v", err)
+	}
+}
+
+func TestAllocateReclaimsStale(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	pool := []IDMap{{ContainerID: 0, HostID: 100000, Size: 200}}
+	removed := map[string]bool{"ns/old": true, "ns/new": true}
+	a := &RangeAllocator{
+		StatePath: filepath.Join(dir, "userns.json"),
+		Size:      100,
+		Stale:     func(owner string) bool { return removed[owner] },
+	}
+	state := map[string]allocation{
+		"ns/old": {
+			UIDs:      []IDMap{{ContainerID: 0, HostID: 100000, Size: 100}},
+			GIDs:      []IDMap{{ContainerID: 0, HostID: 100000, Size: 100}},
+			Allocated: time.Now().Add(-2 * AllocationGracePeriod),
+		},
+		"ns/new": {
+			UIDs:      []IDMap{{ContainerID: 0, HostID: 100100, Size: 100}},
+			GIDs:      []IDMap{{ContainerID: 0, HostID: 100100, Size: 100}},
+			Allocated: time.Now(),
+		},
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(a.StatePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the allocation older than the grace period is reclaimed
+	m, err := a.allocateFrom("ns/old", pool, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.UIDs()[0].HostID != 100000 {
+		t.Errorf("expected the stale slice to be reclaimed, got %v", m.UIDs())
+	}
+	if _, err := a.allocateFrom("ns/other", pool, pool); err == nil {
+		t.Error("expected an allocation within the grace period to be kept")
+	}
+}
@@ -0,0 +1,104 @@
+// +build !windows
+
+package idtools
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+)
+
+// getent exits with this status when a key is not in the database
+const getentNotFound = 2
+
+// GetentResolver looks up users and groups with `getent`, which queries the
+// databases configured in the host's nsswitch.conf, such as LDAP or SSSD
+type GetentResolver struct {
+	once sync.Once
+	cmd  string
+	err  error
+}
+
+// LookupUser returns the user with the given name
+func (r *GetentResolver) LookupUser(name string) (user.User, error) {
+	return r.user(name)
+}
+
+// LookupUID returns the user with the given UID
+func (r *GetentResolver) LookupUID(uid int) (user.User, error) {
+	return r.user(strconv.Itoa(uid))
+}
+
+// LookupGroup returns the group with the given name
+func (r *GetentResolver) LookupGroup(name string) (user.Group, error) {
+	return r.group(name)
+}
+
+// LookupGID returns the group with the given GID
+func (r *GetentResolver) LookupGID(gid int) (user.Group, error) {
+	return r.group(strconv.Itoa(gid))
+}
+
+func (r *GetentResolver) user(key string) (user.User, error) {
+	out, err := r.getent("passwd", key)
+	if err != nil {
+		return user.User{}, err
+	}
+	if out == nil {
+		return user.User{}, &UnknownUserError{User: key}
+	}
+	users, err := user.ParsePasswd(bytes.NewReader(out))
+	if err != nil {
+		return user.User{}, &DatabaseError{Source: "getent passwd", Err: err}
+	}
+	if len(users) == 0 {
+		return user.User{}, &UnknownUserError{User: key}
+	}
+	return users[0], nil
+}
+
+func (r *GetentResolver) group(key string) (user.Group, error) {
+	out, err := r.getent("group", key)
+	if err != nil {
+		return user.Group{}, err
+	}
+	if out == nil {
+		return user.Group{}, &UnknownGroupError{Group: key}
+	}
+	groups, err := user.ParseGroup(bytes.NewReader(out))
+	if err != nil {
+		return user.Group{}, &DatabaseError{Source: "getent group", Err: err}
+	}
+	if len(groups) == 0 {
+		return user.Group{}, &UnknownGroupError{Group: key}
+	}
+	return groups[0], nil
+}
+
+// getent returns the output of `getent db key`, or nil if the key is not in
+// the database. The getent binary is only looked up once; without one, as on
+// busybox systems, there are no databases to query besides the files, so
+// every key is reported as not in the database.
+func (r *GetentResolver) getent(db, key string) ([]byte, error) {
+	r.once.Do(func() { r.cmd, r.err = resolveBinary("getent") })
+	if r.err != nil {
+		return nil, nil
+	}
+	out, err := exec.Command(r.cmd, db, key).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if exitErr.ExitCode() == getentNotFound {
+				return nil, nil
+			}
+			if stderr := bytes.TrimSpace(exitErr.Stderr); len(stderr) > 0 {
+				err = fmt.Errorf("%v: %s", err, stderr)
+			}
+		}
+		return nil, &DatabaseError{Source: "getent " + db, Err: err}
+	}
+	return out, nil
+}
@@ -0,0 +1,242 @@
+package idtools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// idMapFile is the format of a file of ID mappings
+type idMapFile struct {
+	UIDs []IDMap `json:"uids" yaml:"uids"`
+	GIDs []IDMap `json:"gids" yaml:"gids"`
+}
+
+// ParseIDMap parses a single ID mapping given as containerID:hostID:size
+func ParseIDMap(spec string) (IDMap, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return IDMap{}, fmt.Errorf("Invalid ID mapping %q: expected containerID:hostID:size", spec)
+	}
+	var ids [3]int
+	for i, part := range parts {
+		id, err := parseID(part)
+		if err != nil {
+			return IDMap{}, fmt.Errorf("Invalid ID mapping %q: %v", spec, err)
+		}
+		ids[i] = id
+	}
+	return IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]}, nil
+}
+
+// parseID parses a 32-bit ID, rejecting IDs above math.MaxInt32 on 32-bit
+// platforms, where they do not fit in an int
+func parseID(s string) (int, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if id > uint64(^uint(0)>>1) {
+		return 0, fmt.Errorf("ID %d is too large for this platform", id)
+	}
+	return int(id), nil
+}
+
+// LoadIDMapFile reads the UID and GID mappings from a file holding "uids" and
+// "gids" lists of ID mappings; as with --gidmap, the GID mappings default to
+// the UID mappings. The file is read as YAML if it has a .yaml or .yml
+// extension, and as JSON otherwise.
+func LoadIDMapFile(path string) ([]IDMap, []IDMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var f idMapFile
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &f)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&f)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot parse ID mapping file %s: %v", path, err)
+	}
+	if len(f.GIDs) == 0 {
+		f.GIDs = f.UIDs
+	}
+	return f.UIDs, f.GIDs, nil
+}
+
+// ValidateIDMap checks that a list of ID mappings can be given to the kernel:
+// every range must be non-empty and within the 32-bit ID space, neither the
+// container nor the host ranges may overlap, and there may not be more
+// ranges than the running kernel supports
+func ValidateIDMap(idMap []IDMap) error {
+	if len(idMap) == 0 {
+		return fmt.Errorf("No ID mappings given")
+	}
+	if max := maxIDMapExtents(); len(idMap) > max {
+		return fmt.Errorf("Too many ID mappings: %d given, the kernel supports at most %d", len(idMap), max)
+	}
+	for _, m := range idMap {
+		if m.ContainerID < 0 || m.HostID < 0 || m.Size <= 0 {
+			return fmt.Errorf("Invalid ID mapping %s", m)
+		}
+		if int64(m.ContainerID)+int64(m.Size)-1 > math.MaxUint32 || int64(m.HostID)+int64(m.Size)-1 > math.MaxUint32 {
+			return fmt.Errorf("ID mapping %s overflows 32-bit IDs", m)
+		}
+	}
+	if a, b, ok := overlapping(idMap, func(m IDMap) int { return m.ContainerID }); ok {
+		return fmt.Errorf("Container ID ranges of mappings %s and %s overlap", a, b)
+	}
+	if a, b, ok := overlapping(idMap, func(m IDMap) int { return m.HostID }); ok {
+		return fmt.Errorf("Host ID ranges of mappings %s and %s overlap", a, b)
+	}
+	return nil
+}
+
+// overlapping returns two mappings whose ranges overlap, with ranges starting
+// at the ID returned by start
+func overlapping(idMap []IDMap, start func(IDMap) int) (IDMap, IDMap, bool) {
+	sorted := make([]IDMap, len(idMap))
+	copy(sorted, idMap)
+	sort.Slice(sorted, func(i, j int) bool { return start(sorted[i]) < start(sorted[j]) })
+	for i := 1; i < len(sorted); i++ {
+		if start(sorted[i-1])+sorted[i-1].Size > start(sorted[i]) {
+			return sorted[i-1], sorted[i], true
+		}
+	}
+	return IDMap{}, IDMap{}, false
+}
+
+// ParseKernelIDMap parses ID mappings in the format of /proc/<pid>/uid_map and
+// gid_map: one "containerID hostID size" line per mapping
+func ParseKernelIDMap(r io.Reader) ([]IDMap, error) {
+	idMap := []IDMap{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Invalid ID map line %q", s.Text())
+		}
+		var ids [3]int
+		for i, field := range fields {
+			id, err := parseID(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ID map line %q: %v", s.Text(), err)
+			}
+			ids[i] = id
+		}
+		idMap = append(idMap, IDMap{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return idMap, s.Err()
+}
+
+// ReadProcIDMaps returns the UID and GID mappings of the user namespace of a
+// process, with host IDs as seen from the caller's user namespace
+func ReadProcIDMaps(pid int) ([]IDMap, []IDMap, error) {
+	uids, err := readKernelIDMap(fmt.Sprintf("/proc/%d/uid_map", pid))
+	if err != nil {
+		return nil, nil, err
+	}
+	gids, err := readKernelIDMap(fmt.Sprintf("/proc/%d/gid_map", pid))
+	if err != nil {
+		return nil, nil, err
+	}
+	return uids, gids, nil
+}
+
+func readKernelIDMap(path string) ([]IDMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	idMap, err := ParseKernelIDMap(f)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse %s: %v", path, err)
+	}
+	return idMap, nil
+}
+
+// RunningInUserNS reports whether the calling process runs in a user namespace
+// other than the initial one, whose map covers the whole 32-bit ID space
+func RunningInUserNS() bool {
+	uids, err := readKernelIDMap("/proc/self/uid_map")
+	if err != nil {
+		// without the file there are no user namespaces, and on 32-bit
+		// platforms the map of the initial one does not fit in an int
+		return false
+	}
+	return !(len(uids) == 1 && uids[0].ContainerID == 0 && uids[0].HostID == 0 &&
+		int64(uids[0].Size) == math.MaxUint32)
+}
+
+// EqualIDMaps reports whether two lists map the same IDs, in any order and
+// however the mappings are split into ranges
+func EqualIDMaps(a, b []IDMap) bool {
+	mergedA, mergedB := mergedIDMap(a), mergedIDMap(b)
+	if len(mergedA) != len(mergedB) {
+		return false
+	}
+	for i := range mergedA {
+		if mergedA[i] != mergedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergedIDMap returns the mappings sorted by container ID, with mappings
+// continuing each other on both sides merged into one
+func mergedIDMap(idMap []IDMap) []IDMap {
+	sorted := make([]IDMap, len(idMap))
+	copy(sorted, idMap)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ContainerID < sorted[j].ContainerID })
+	merged := []IDMap{}
+	for _, m := range sorted {
+		if m.Size == 0 {
+			continue
+		}
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.ContainerID+last.Size == m.ContainerID && last.HostID+last.Size == m.HostID {
+				last.Size += m.Size
+				continue
+			}
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// FormatIDMap returns a list of mappings as comma-separated
+// containerID:hostID:size mappings
+func FormatIDMap(idMap []IDMap) string {
+	parts := make([]string, len(idMap))
+	for i, m := range idMap {
+		parts[i] = m.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// String returns the mapping as containerID:hostID:size
+func (m IDMap) String() string {
+	return fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size)
+}
@@ -0,0 +1,23 @@
+// +build 386 arm mips mipsle
+
+package idtools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDMapLargeIDs(t *testing.T) {
+	for _, spec := range []string{"0:2147483648:1", "2147483648:0:1", "0:0:4294967295"} {
+		if idMap, err := ParseIDMap(spec); err == nil {
+			t.Errorf("ParseIDMap(%q) = %v, expected an error", spec, idMap)
+		}
+	}
+	idMap, err := ParseIDMap("0:2147483647:1")
+	if expected := (IDMap{ContainerID: 0, HostID: 2147483647, Size: 1}); err != nil || idMap != expected {
+		t.Errorf("ParseIDMap = %v, %v, expected %v", idMap, err, expected)
+	}
+	if idMaps, err := ParseKernelIDMap(strings.NewReader("         0          0 4294967295\n")); err == nil {
+		t.Errorf("ParseKernelIDMap = %v, expected an error", idMaps)
+	}
+}
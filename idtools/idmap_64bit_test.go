@@ -0,0 +1,34 @@
+// +build !386,!arm,!mips,!mipsle
+
+package idtools
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIDMapLargeIDs(t *testing.T) {
+	idMap, err := ParseIDMap("0:4294967295:1")
+	if expected := (IDMap{ContainerID: 0, HostID: math.MaxUint32, Size: 1}); err != nil || idMap != expected {
+		t.Errorf("ParseIDMap = %v, %v, expected %v", idMap, err, expected)
+	}
+	for _, tc := range []struct {
+		name  string
+		idMap []IDMap
+		valid bool
+	}{
+		{"last ID", []IDMap{{ContainerID: 0, HostID: math.MaxUint32, Size: 1}}, true},
+		{"host overflow", []IDMap{{ContainerID: 0, HostID: math.MaxUint32, Size: 2}}, false},
+		{"container overflow", []IDMap{{ContainerID: math.MaxUint32 - 10, HostID: 0, Size: 65536}}, false},
+	} {
+		if err := ValidateIDMap(tc.idMap); tc.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+	idMaps, err := ParseKernelIDMap(strings.NewReader("         0          0 4294967295\n"))
+	if expected := []IDMap{{ContainerID: 0, HostID: 0, Size: math.MaxUint32}}; err != nil || !reflect.DeepEqual(idMaps, expected) {
+		t.Errorf("ParseKernelIDMap = %v, %v, expected %v", idMaps, err, expected)
+	}
+}
@@ -0,0 +1,202 @@
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIDMap(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		idMap IDMap
+		valid bool
+	}{
+		{"0:100000:65536", IDMap{ContainerID: 0, HostID: 100000, Size: 65536}, true},
+		{"1000:1000:1", IDMap{ContainerID: 1000, HostID: 1000, Size: 1}, true},
+		{"", IDMap{}, false},
+		{"0:100000", IDMap{}, false},
+		{"0:100000:65536:1", IDMap{}, false},
+		{"a:100000:65536", IDMap{}, false},
+		{"0:100000:", IDMap{}, false},
+		{"-1:100000:65536", IDMap{}, false},
+		{"0:4294967296:1", IDMap{}, false},
+		{" 0:100000:65536", IDMap{}, false},
+	} {
+		idMap, err := ParseIDMap(tc.spec)
+		if tc.valid != (err == nil) {
+			t.Errorf("ParseIDMap(%q): unexpected error %v", tc.spec, err)
+			continue
+		}
+		if idMap != tc.idMap {
+			t.Errorf("ParseIDMap(%q) = %v, expected %v", tc.spec, idMap, tc.idMap)
+		}
+	}
+}
+
+func TestValidateIDMap(t *testing.T) {
+	tooMany := make([]IDMap, maxIDMapExtents()+1)
+	for i := range tooMany {
+		tooMany[i] = IDMap{ContainerID: i, HostID: 100000 + i, Size: 1}
+	}
+	for _, tc := range []struct {
+		name  string
+		idMap []IDMap
+		valid bool
+	}{
+		{"single", []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}, true},
+		{"adjacent", []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1000, HostID: 200000, Size: 1000},
+		}, true},
+		{"unsorted", []IDMap{
+			{ContainerID: 1000, HostID: 100000, Size: 1000},
+			{ContainerID: 0, HostID: 200000, Size: 1000},
+		}, true},
+		{"empty", nil, false},
+		{"zero size", []IDMap{{ContainerID: 0, HostID: 100000, Size: 0}}, false},
+		{"negative", []IDMap{{ContainerID: -1, HostID: 100000, Size: 1}}, false},
+		{"overlapping container ranges", []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 999, HostID: 200000, Size: 1000},
+		}, false},
+		{"overlapping host ranges", []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1000, HostID: 100500, Size: 1000},
+		}, false},
+		{"too many extents", tooMany, false},
+		{"most extents", tooMany[1:], true},
+	} {
+		if err := ValidateIDMap(tc.idMap); tc.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+}
+
+func TestLoadIDMapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idtools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uids := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	gids := []IDMap{{ContainerID: 0, HostID: 200000, Size: 65536}}
+	for _, tc := range []struct {
+		name    string
+		content string
+		uids    []IDMap
+		gids    []IDMap
+		valid   bool
+	}{
+		{"both.json", `{"uids": [{"container_id": 0, "host_id": 100000, "size": 65536}],
+			"gids": [{"container_id": 0, "host_id": 200000, "size": 65536}]}`, uids, gids, true},
+		{"uids.json", `{"uids": [{"container_id": 0, "host_id": 100000, "size": 65536}]}`, uids, uids, true},
+		{"both.yaml", "uids:\n- container_id: 0\n  host_id: 100000\n  size: 65536\n" +
+			"gids:\n- container_id: 0\n  host_id: 200000\n  size: 65536\n", uids, gids, true},
+		{"uids.yml", "uids:\n- container_id: 0\n  host_id: 100000\n  size: 65536\n", uids, uids, true},
+		{"unknown.json", `{"uids": [], "users": []}`, nil, nil, false},
+		{"unknown.yaml", "uid:\n- container_id: 0\n", nil, nil, false},
+		{"broken.json", `{"uids": [`, nil, nil, false},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		u, g, err := LoadIDMapFile(path)
+		if tc.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(u, tc.uids) || !reflect.DeepEqual(g, tc.gids) {
+			t.Errorf("%s: got %v and %v, expected %v and %v", tc.name, u, g, tc.uids, tc.gids)
+		}
+	}
+	if _, _, err := LoadIDMapFile(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestParseKernelIDMap(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		idMap   []IDMap
+		valid   bool
+	}{
+		{"several mappings", "0 100000 1000\n1000 1000 1\n1001 101001 64535\n", []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1000, HostID: 1000, Size: 1},
+			{ContainerID: 1001, HostID: 101001, Size: 64535},
+		}, true},
+		{"blank lines", "\n0 100000 65536\n\n", []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}, true},
+		{"no trailing newline", "0 100000 65536", []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}, true},
+		{"no mappings", "", []IDMap{}, true},
+		{"missing field", "0 100000\n", nil, false},
+		{"extra field", "0 100000 65536 1\n", nil, false},
+		{"not a number", "0 root 65536\n", nil, false},
+		{"negative", "0 -1 65536\n", nil, false},
+		{"too large", "0 4294967296 1\n", nil, false},
+	} {
+		idMap, err := ParseKernelIDMap(strings.NewReader(tc.content))
+		if tc.valid != (err == nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(idMap, tc.idMap) {
+			t.Errorf("%s: got %v, expected %v", tc.name, idMap, tc.idMap)
+		}
+	}
+}
+
+func TestEqualIDMaps(t *testing.T) {
+	whole := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	for _, tc := range []struct {
+		name  string
+		a, b  []IDMap
+		equal bool
+	}{
+		{"same", whole, []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}, true},
+		{"both empty", nil, []IDMap{}, true},
+		{"empty", whole, nil, false},
+		{"reordered", []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1},
+			{ContainerID: 1, HostID: 1000, Size: 1},
+		}, []IDMap{
+			{ContainerID: 1, HostID: 1000, Size: 1},
+			{ContainerID: 0, HostID: 100000, Size: 1},
+		}, true},
+		{"split", whole, []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1000, HostID: 101000, Size: 64536},
+		}, true},
+		{"split and reordered", []IDMap{
+			{ContainerID: 30000, HostID: 130000, Size: 35536},
+			{ContainerID: 0, HostID: 100000, Size: 10000},
+			{ContainerID: 10000, HostID: 110000, Size: 20000},
+		}, []IDMap{
+			{ContainerID: 1000, HostID: 101000, Size: 64536},
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+		}, true},
+		{"adjacent container IDs only", whole, []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1000, HostID: 201000, Size: 64536},
+		}, false},
+		{"different host", whole, []IDMap{{ContainerID: 0, HostID: 200000, Size: 65536}}, false},
+		{"different size", whole, []IDMap{{ContainerID: 0, HostID: 100000, Size: 65535}}, false},
+		{"gap", whole, []IDMap{
+			{ContainerID: 0, HostID: 100000, Size: 1000},
+			{ContainerID: 1001, HostID: 101001, Size: 64535},
+		}, false},
+	} {
+		if equal := EqualIDMaps(tc.a, tc.b); equal != tc.equal {
+			t.Errorf("%s: got %t, expected %t", tc.name, equal, tc.equal)
+		}
+		if equal := EqualIDMaps(tc.b, tc.a); equal != tc.equal {
+			t.Errorf("%s reversed: got %t, expected %t", tc.name, equal, tc.equal)
+		}
+	}
+}
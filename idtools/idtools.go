@@ -5,17 +5,15 @@ import (
 	"fmt"
 	"os"
 	"sort"
-	"strconv"
-	"strings"
 )
 
 // IDMap contains a single entry for user namespace range remapping. An array
 // of IDMap entries represents the structure that will be provided to the Linux
 // kernel for creating a user namespace.
 type IDMap struct {
-	ContainerID int `json:"container_id"`
-	HostID      int `json:"host_id"`
-	Size        int `json:"size"`
+	ContainerID int `json:"container_id" yaml:"container_id"`
+	HostID      int `json:"host_id" yaml:"host_id"`
+	Size        int `json:"size" yaml:"size"`
 }
 
 type subIDRange struct {
@@ -214,18 +212,29 @@ func createIDMap(subidRanges ranges) []IDMap {
 	return idMap
 }
 
+// parseSubuid returns the /etc/subuid ranges of a user, listed under either
+// the user's name or UID
 func parseSubuid(username string) (ranges, error) {
-	return parseSubidFile(subuidFileName, username)
+	if username == "ALL" {
+		return parseSubidFile(subuidFileName, username)
+	}
+	return parseSubidFile(subuidFileName, subuidOwners(username)...)
 }
 
-func parseSubgid(username string) (ranges, error) {
-	return parseSubidFile(subgidFileName, username)
+// parseSubgid returns the /etc/subgid ranges of a group, listed under either
+// the group's name or GID
+func parseSubgid(groupname string) (ranges, error) {
+	if groupname == "ALL" {
+		return parseSubidFile(subgidFileName, groupname)
+	}
+	return parseSubidFile(subgidFileName, subgidOwners(groupname)...)
 }
 
 // parseSubidFile will read the appropriate file (/etc/subuid or /etc/subgid)
-// and return all found ranges for a specified username. If the special value
-// "ALL" is supplied for username, then all ranges in the file will be returned
-func parseSubidFile(path, username string) (ranges, error) {
+// and return all found ranges for any of the specified owners. If the special
+// value "ALL" is supplied as owner, then all ranges in the file will be
+// returned
+func parseSubidFile(path string, owners ...string) (ranges, error) {
 	var rangeList ranges
 
 	subidFile, err := os.Open(path)
@@ -236,29 +245,25 @@ func parseSubidFile(path, username string) (ranges, error) {
 
 	s := bufio.NewScanner(subidFile)
 	for s.Scan() {
-		if err := s.Err(); err != nil {
+		r, err := parseSubIDLine(path, s.Text())
+		if err != nil {
 			return rangeList, err
 		}
-
-		text := strings.TrimSpace(s.Text())
-		if text == "" || strings.HasPrefix(text, "#") {
-			continue
-		}
-		parts := strings.Split(text, ":")
-		if len(parts) != 3 {
-			return rangeList, fmt.Errorf("Cannot parse subuid/gid information: Format not correct for %s file", path)
-		}
-		if parts[0] == username || username == "ALL" {
-			startid, err := strconv.Atoi(parts[1])
-			if err != nil {
-				return rangeList, fmt.Errorf("String to int conversion failed during subuid/gid parsing of %s: %v", path, err)
-			}
-			length, err := strconv.Atoi(parts[2])
-			if err != nil {
-				return rangeList, fmt.Errorf("String to int conversion failed during subuid/gid parsing of %s: %v", path, err)
-			}
-			rangeList = append(rangeList, subIDRange{startid, length})
+		if r != nil && ownedBy(r.Owner, owners) {
+			rangeList = append(rangeList, subIDRange{r.Start, r.Length})
 		}
 	}
+	if err := s.Err(); err != nil {
+		return rangeList, err
+	}
 	return rangeList, nil
 }
+
+func ownedBy(owner string, owners []string) bool {
+	for _, o := range owners {
+		if o == owner || o == "ALL" {
+			return true
+		}
+	}
+	return false
+}
@@ -5,129 +5,261 @@ package idtools
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"os"
-	"strings"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/opencontainers/runc/libcontainer/user"
-)
-
-var (
-	entOnce   sync.Once
-	getentCmd string
+	"golang.org/x/sys/unix"
 )
 
 func mkdirAs(path string, mode os.FileMode, ownerUID, ownerGID int, mkAll, chownExisting bool) error {
-	// to preserve simple vendoring for this example code, gutting this function
-	// to limit a group of `pkg/*` content required
-	return nil
-}
+	// make an array containing the original path asked for, plus (for mkAll == true)
+	// all path components leading up to the complete path that don't exist before we MkdirAll
+	// so that we can chown all of them properly at the end.  If chownExisting is false, we won't
+	// chown the full directory path if it exists
+	var paths []string
 
-func accessible(isOwner, isGroup bool, perms os.FileMode) bool {
-	if isOwner && (perms&0100 == 0100) {
-		return true
+	fi, err := os.Stat(path)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: unix.ENOTDIR}
+		}
+		if !chownExisting {
+			return nil
+		}
+		// short-circuit--we were called with an existing directory and chown was requested
+		return chownIfNeeded(path, ownerUID, ownerGID, fi)
 	}
-	if isGroup && (perms&0010 == 0010) {
-		return true
+	if !os.IsNotExist(err) {
+		return err
 	}
-	if perms&0001 == 0001 {
-		return true
+	paths = []string{path}
+
+	if mkAll {
+		// walk back to "/" looking for directories which do not exist
+		// and add them to the paths array for chown after creation
+		dirPath := path
+		for {
+			dirPath = filepath.Dir(dirPath)
+			if dirPath == "/" || dirPath == "." {
+				break
+			}
+			if _, err := os.Stat(dirPath); err == nil {
+				break
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+			paths = append(paths, dirPath)
+		}
+		if err := os.MkdirAll(path, mode); err != nil {
+			return err
+		}
+	} else if err := os.Mkdir(path, mode); err != nil {
+		return err
 	}
-	return false
+	// chown the requested path and any parents that didn't exist when we
+	// called MkdirAll
+	for _, pathComponent := range paths {
+		if err := chownIfNeeded(pathComponent, ownerUID, ownerGID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// LookupUser uses traditional local system files lookup (from libcontainer/user) on a username,
-// followed by a call to `getent` for supporting host configured non-files passwd and group dbs
-func LookupUser(username string) (user.User, error) {
-	// first try a local system files lookup using existing capabilities
-	usr, err := user.LookupUser(username)
-	if err == nil {
-		return usr, nil
+// chownIfNeeded changes the owner of path unless it already has the given
+// owner, using fi if it is not nil rather than calling stat
+func chownIfNeeded(path string, uid, gid int, fi os.FileInfo) error {
+	if fi == nil {
+		var err error
+		if fi, err = os.Stat(path); err != nil {
+			return err
+		}
 	}
-	// local files lookup failed; attempt to call `getent` to query configured passwd dbs
-	usr, err = getentUser(fmt.Sprintf("%s %s", "passwd", username))
-	if err != nil {
-		return user.User{}, err
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+		return nil
 	}
-	return usr, nil
+	return os.Chown(path, uid, gid)
 }
 
-// LookupUID uses traditional local system files lookup (from libcontainer/user) on a uid,
-// followed by a call to `getent` for supporting host configured non-files passwd and group dbs
-func LookupUID(uid int) (user.User, error) {
-	// first try a local system files lookup using existing capabilities
-	usr, err := user.LookupUid(uid)
-	if err == nil {
-		return usr, nil
+// CanAccess takes a valid (existing) directory and a uid, gid pair and determines
+// if that uid, gid pair has access (execute bit) to the directory
+func CanAccess(path string, pair IDPair) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
 	}
-	// local files lookup failed; attempt to call `getent` to query configured passwd dbs
-	return getentUser(fmt.Sprintf("%s %d", "passwd", uid))
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return accessible(int(st.Uid) == pair.UID, int(st.Gid) == pair.GID, fi.Mode().Perm())
 }
 
-func getentUser(args string) (user.User, error) {
-	reader, err := callGetent(args)
+// CheckParentAccess checks that a uid, gid pair, such as a remapped root, can
+// traverse every directory leading to path, returning an error naming the
+// first one it cannot
+func CheckParentAccess(path string, pair IDPair) error {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return user.User{}, err
+		return err
+	}
+	var parents []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if dir == "/" {
+			break
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if !CanAccess(parents[i], pair) {
+			return fmt.Errorf("Directory %s is not searchable by %d:%d; it needs the execute permission for them", parents[i], pair.UID, pair.GID)
+		}
+	}
+	return nil
+}
+
+// maxIDMapExtents returns the number of ranges the running kernel accepts in
+// a uid_map or gid_map: 340 since Linux 4.15, and 5 before
+func maxIDMapExtents() int {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return 5
+	}
+	release := uts.Release[:]
+	if n := bytes.IndexByte(release, 0); n >= 0 {
+		release = release[:n]
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(release), "%d.%d", &major, &minor); err != nil {
+		return 5
 	}
-	users, err := user.ParsePasswd(reader)
+	if major > 4 || (major == 4 && minor >= 15) {
+		return 340
+	}
+	return 5
+}
+
+// lockFile takes an exclusive lock on the file at path, creating it if needed,
+// and returns the function releasing the lock
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
-		return user.User{}, err
+		return nil, err
 	}
-	if len(users) == 0 {
-		return user.User{}, fmt.Errorf("getent failed to find passwd entry for %q", strings.Split(args, " ")[1])
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
 	}
-	return users[0], nil
+	// closing the file releases the lock
+	return f.Close, nil
 }
 
-// LookupGroup uses traditional local system files lookup (from libcontainer/user) on a group name,
-// followed by a call to `getent` for supporting host configured non-files passwd and group dbs
-func LookupGroup(groupname string) (user.Group, error) {
-	// first try a local system files lookup using existing capabilities
-	group, err := user.LookupGroup(groupname)
+// subuidOwners returns the owner names under which the subordinate UIDs of a
+// user, given by name or UID, may be listed: its name and its UID
+func subuidOwners(username string) []string {
+	owners := []string{username}
+	var (
+		usr user.User
+		err error
+	)
+	if uid, cerr := strconv.Atoi(username); cerr == nil {
+		usr, err = LookupUID(uid)
+	} else {
+		usr, err = LookupUser(username)
+	}
 	if err == nil {
-		return group, nil
+		owners = append(owners, usr.Name, strconv.Itoa(usr.Uid))
 	}
-	// local files lookup failed; attempt to call `getent` to query configured group dbs
-	return getentGroup(fmt.Sprintf("%s %s", "group", groupname))
+	return owners
 }
 
-// LookupGID uses traditional local system files lookup (from libcontainer/user) on a group ID,
-// followed by a call to `getent` for supporting host configured non-files passwd and group dbs
-func LookupGID(gid int) (user.Group, error) {
-	// first try a local system files lookup using existing capabilities
-	group, err := user.LookupGid(gid)
+// subgidOwners returns the owner names under which the subordinate GIDs of a
+// group, given by name or GID, may be listed: its name and its GID, and since
+// shadow-utils lists subordinate GIDs under users, the name and UID of the
+// user with the group's name or ID
+func subgidOwners(groupname string) []string {
+	owners := subuidOwners(groupname)
+	var (
+		group user.Group
+		err   error
+	)
+	if gid, cerr := strconv.Atoi(groupname); cerr == nil {
+		group, err = LookupGID(gid)
+	} else {
+		group, err = LookupGroup(groupname)
+	}
 	if err == nil {
-		return group, nil
+		owners = append(owners, group.Name, strconv.Itoa(group.Gid))
 	}
-	// local files lookup failed; attempt to call `getent` to query configured group dbs
-	return getentGroup(fmt.Sprintf("%s %d", "group", gid))
+	return owners
 }
 
-func getentGroup(args string) (user.Group, error) {
-	reader, err := callGetent(args)
-	if err != nil {
-		return user.Group{}, err
+func accessible(isOwner, isGroup bool, perms os.FileMode) bool {
+	if isOwner && (perms&0100 == 0100) {
+		return true
 	}
-	groups, err := user.ParseGroup(reader)
-	if err != nil {
-		return user.Group{}, err
+	if isGroup && (perms&0010 == 0010) {
+		return true
 	}
-	if len(groups) == 0 {
-		return user.Group{}, fmt.Errorf("getent failed to find groups entry for %q", strings.Split(args, " ")[1])
+	if perms&0001 == 0001 {
+		return true
 	}
-	return groups[0], nil
+	return false
 }
 
-func callGetent(args string) (io.Reader, error) {
-	entOnce.Do(func() { getentCmd, _ = resolveBinary("getent") })
-	// if no `getent` command on host, can't do anything else
-	if getentCmd == "" {
-		return nil, fmt.Errorf("")
-	}
-	out, err := execCmd(getentCmd, args)
-	if err != nil {
-		return nil, fmt.Errorf("getent failed")
+// defaultResolver is used by LookupUser, LookupUID, LookupGroup and LookupGID.
+// It reads the host's local files and falls back to `getent` for host
+// configured non-files passwd and group dbs.
+var (
+	resolverMu      sync.RWMutex
+	defaultResolver Resolver = NewCachingResolver(ChainResolver{&FilesResolver{}, &GetentResolver{}}, DefaultResolverTTL)
+)
+
+// DefaultResolverTTL is how long the default resolver caches lookups
+const DefaultResolverTTL = 30 * time.Second
+
+// SetResolver replaces the resolver used by the package's lookup functions
+func SetResolver(r Resolver) {
+	resolverMu.Lock()
+	defaultResolver = r
+	resolverMu.Unlock()
+}
+
+func getResolver() Resolver {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	return defaultResolver
+}
+
+// flushResolver drops the lookups cached by the default resolver, for use
+// after users or groups were added or removed
+func flushResolver() {
+	if r, ok := getResolver().(*CachingResolver); ok {
+		r.Flush()
 	}
-	return bytes.NewReader(out), nil
+}
+
+// LookupUser looks up a user by name with the package's resolver
+func LookupUser(username string) (user.User, error) {
+	return getResolver().LookupUser(username)
+}
+
+// LookupUID looks up a user by UID with the package's resolver
+func LookupUID(uid int) (user.User, error) {
+	return getResolver().LookupUID(uid)
+}
+
+// LookupGroup looks up a group by name with the package's resolver
+func LookupGroup(groupname string) (user.Group, error) {
+	return getResolver().LookupGroup(groupname)
+}
+
+// LookupGID looks up a group by GID with the package's resolver
+func LookupGID(gid int) (user.Group, error) {
+	return getResolver().LookupGID(gid)
 }
@@ -0,0 +1,177 @@
+// +build !windows
+
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func requireRoot(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing ownership requires root")
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "idtools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func assertOwner(t *testing.T, path string, uid, gid int) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if int(st.Uid) != uid || int(st.Gid) != gid {
+		t.Errorf("%s is owned by %d:%d, expected %d:%d", path, st.Uid, st.Gid, uid, gid)
+	}
+}
+
+func TestMkdirAllAndChown(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "usr")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(existing, "share", "lib")
+	if err := MkdirAllAndChown(path, 0755, IDPair{UID: 99999, GID: 99999}); err != nil {
+		t.Fatal(err)
+	}
+	assertOwner(t, path, 99999, 99999)
+	assertOwner(t, filepath.Dir(path), 99999, 99999)
+	assertOwner(t, existing, 0, 0)
+
+	// an existing directory is chowned as well
+	if err := MkdirAllAndChown(existing, 0755, IDPair{UID: 88888, GID: 88888}); err != nil {
+		t.Fatal(err)
+	}
+	assertOwner(t, existing, 88888, 88888)
+}
+
+func TestMkdirAllAndChownNew(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "usr")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(existing, "share")
+	if err := MkdirAllAndChownNew(path, 0755, IDPair{UID: 99999, GID: 99999}); err != nil {
+		t.Fatal(err)
+	}
+	assertOwner(t, path, 99999, 99999)
+
+	// existing directories keep their owner
+	if err := MkdirAllAndChownNew(path, 0755, IDPair{UID: 88888, GID: 88888}); err != nil {
+		t.Fatal(err)
+	}
+	assertOwner(t, path, 99999, 99999)
+	assertOwner(t, existing, 0, 0)
+}
+
+func TestMkdirAndChown(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a")
+	if err := MkdirAndChown(path, 0755, IDPair{UID: 99999, GID: 99999}); err != nil {
+		t.Fatal(err)
+	}
+	assertOwner(t, path, 99999, 99999)
+
+	// parents are not created
+	if err := MkdirAndChown(filepath.Join(dir, "b", "c"), 0755, IDPair{UID: 99999, GID: 99999}); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestMkdirAsNotDirectory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MkdirAllAndChown(file, 0755, IDPair{UID: os.Getuid(), GID: os.Getgid()}); err == nil {
+		t.Error("expected an error creating a directory over a file")
+	}
+}
+
+func TestCanAccess(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a")
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 99999, 88888); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		mode   os.FileMode
+		pair   IDPair
+		access bool
+	}{
+		{0700, IDPair{UID: 99999, GID: 1}, true},
+		{0700, IDPair{UID: 1, GID: 88888}, false},
+		{0710, IDPair{UID: 1, GID: 88888}, true},
+		{0710, IDPair{UID: 1, GID: 1}, false},
+		{0701, IDPair{UID: 1, GID: 1}, true},
+		{0600, IDPair{UID: 99999, GID: 88888}, false},
+	} {
+		if err := os.Chmod(path, tc.mode); err != nil {
+			t.Fatal(err)
+		}
+		if access := CanAccess(path, tc.pair); access != tc.access {
+			t.Errorf("CanAccess(%o, %v) = %v, expected %v", tc.mode, tc.pair, access, tc.access)
+		}
+	}
+	if CanAccess(filepath.Join(dir, "missing"), IDPair{}) {
+		t.Error("expected a missing directory not to be accessible")
+	}
+}
+
+func TestCheckParentAccess(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := filepath.Join(dir, "parent")
+	if err := os.Mkdir(parent, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(parent, "root")
+	if err := MkdirAndChown(path, 0755, IDPair{UID: 99999, GID: 99999}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckParentAccess(path, IDPair{UID: 99999, GID: 99999}); err == nil {
+		t.Error("expected an error for a parent directory without search permission")
+	}
+	if err := os.Chmod(parent, 0711); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckParentAccess(path, IDPair{UID: 99999, GID: 99999}); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,6 +3,7 @@
 package idtools
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/moby/moby/pkg/system"
@@ -23,3 +24,33 @@ func mkdirAs(path string, mode os.FileMode, ownerUID, ownerGID int, mkAll, chown
 func CanAccess(path string, pair IDPair) bool {
 	return true
 }
+
+// CheckParentAccess checks that a uid, gid pair can traverse every directory
+// leading to path; Windows has no such permissions, so it always succeeds
+func CheckParentAccess(path string, pair IDPair) error {
+	return nil
+}
+
+// maxIDMapExtents returns the number of ranges supported in an ID map; there
+// are no user namespaces on Windows, so the Linux limit is used
+func maxIDMapExtents() int {
+	return 340
+}
+
+// lockFile is not supported on Windows, which has no subordinate ID ranges to
+// allocate from
+func lockFile(path string) (func() error, error) {
+	return nil, fmt.Errorf("File locking is not supported on Windows")
+}
+
+// subuidOwners returns the owner names of a user's subordinate UIDs; users
+// can't be looked up on Windows, so only the given name is used
+func subuidOwners(username string) []string {
+	return []string{username}
+}
+
+// subgidOwners returns the owner names of a group's subordinate GIDs; groups
+// can't be looked up on Windows, so only the given name is used
+func subgidOwners(groupname string) []string {
+	return []string{groupname}
+}
@@ -0,0 +1,62 @@
+package idtools
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const loginDefsFileName = "/etc/login.defs"
+
+// subIDLimits are the bounds of the IDs available for subordinate ranges and
+// the default size of a new range
+type subIDLimits struct {
+	Min   int
+	Max   int
+	Count int
+}
+
+// defaults used by shadow-utils when login.defs doesn't set a value
+var defaultSubIDLimits = subIDLimits{
+	Min:   100000,
+	Max:   600100000,
+	Count: 65536,
+}
+
+// loadSubIDLimits reads the <prefix>_MIN, <prefix>_MAX and <prefix>_COUNT
+// settings, with prefix SUB_UID or SUB_GID, from a login.defs file. Settings
+// which are missing or invalid, or the whole file if it can't be read, are
+// replaced by the shadow-utils defaults.
+func loadSubIDLimits(path, prefix string) subIDLimits {
+	limits := defaultSubIDLimits
+	f, err := os.Open(path)
+	if err != nil {
+		return limits
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// like shadow-utils, accept decimal, octal and hex values
+		value, err := strconv.ParseInt(fields[1], 0, 64)
+		if err != nil || value < 0 {
+			continue
+		}
+		switch fields[0] {
+		case prefix + "_MIN":
+			limits.Min = int(value)
+		case prefix + "_MAX":
+			limits.Max = int(value)
+		case prefix + "_COUNT":
+			if value > 0 {
+				limits.Count = int(value)
+			}
+		}
+	}
+	return limits
+}
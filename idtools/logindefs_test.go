@@ -0,0 +1,45 @@
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSubIDLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idtools-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		prefix  string
+		limits  subIDLimits
+	}{
+		{"empty", "", "SUB_UID", defaultSubIDLimits},
+		{"decimal", "SUB_UID_MIN 200000\nSUB_UID_MAX 300000\nSUB_UID_COUNT 1000\n", "SUB_UID",
+			subIDLimits{Min: 200000, Max: 300000, Count: 1000}},
+		{"other prefix", "SUB_UID_MIN 200000\nSUB_GID_MIN\t\t300000\n", "SUB_GID",
+			subIDLimits{Min: 300000, Max: defaultSubIDLimits.Max, Count: defaultSubIDLimits.Count}},
+		{"octal and hex", "SUB_GID_MIN 0303240\nSUB_GID_COUNT 0x10000\n", "SUB_GID",
+			subIDLimits{Min: 100000, Max: defaultSubIDLimits.Max, Count: 65536}},
+		{"comments", "# SUB_UID_MIN 1\n#SUB_UID_MAX 2\n  SUB_UID_COUNT 100 # trailing\n", "SUB_UID",
+			subIDLimits{Min: defaultSubIDLimits.Min, Max: defaultSubIDLimits.Max, Count: 100}},
+		{"invalid", "SUB_UID_MIN -1\nSUB_UID_MAX many\nSUB_UID_COUNT 0\nSUB_UID_MIN\n", "SUB_UID", defaultSubIDLimits},
+	} {
+		path := filepath.Join(dir, tc.name)
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if limits := loadSubIDLimits(path, tc.prefix); limits != tc.limits {
+			t.Errorf("%s: got %+v, expected %+v", tc.name, limits, tc.limits)
+		}
+	}
+	if limits := loadSubIDLimits(filepath.Join(dir, "missing"), "SUB_UID"); limits != defaultSubIDLimits {
+		t.Errorf("missing file: got %+v, expected the defaults", limits)
+	}
+}
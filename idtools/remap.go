@@ -0,0 +1,65 @@
+package idtools
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RemapOptions controls how RemapOwnership changes a tree
+type RemapOptions struct {
+	// DryRun reports the changes without making them
+	DryRun bool
+	// Changed, if set, is called for every file whose owner is changed, or
+	// would be in a dry run
+	Changed func(path string, from, to IDPair)
+}
+
+// RemapReport summarizes the changes made by RemapOwnership
+type RemapReport struct {
+	// Changed is the number of files whose owner was changed, or would be
+	Changed int
+	// Unmapped lists the files left unchanged because one of their IDs,
+	// including those named in their ACLs, is not mapped
+	Unmapped []UnmappedFile
+}
+
+// UnmappedFile is a file holding an ID which cannot be remapped
+type UnmappedFile struct {
+	Path string
+	Err  error
+}
+
+func (u UnmappedFile) String() string {
+	return fmt.Sprintf("%s: %v", u.Path, u.Err)
+}
+
+// unmappedIDError reports an ID which is outside the mappings it is remapped
+// from or to
+type unmappedIDError struct {
+	err error
+}
+
+func (e *unmappedIDError) Error() string {
+	return e.err.Error()
+}
+
+// isUnmapped returns whether err, or an error it wraps, reports an ID which
+// cannot be remapped
+func isUnmapped(err error) bool {
+	var uerr *unmappedIDError
+	return errors.As(err, &uerr)
+}
+
+// remapID translates a host ID owned under one mapping to the host ID of the
+// same container ID under another
+func remapID(id int, from, to []IDMap) (int, error) {
+	contID, err := toContainer(id, from)
+	if err != nil {
+		return -1, &unmappedIDError{err: err}
+	}
+	hostID, err := toHost(contID, to)
+	if err != nil {
+		return -1, &unmappedIDError{err: err}
+	}
+	return hostID, nil
+}
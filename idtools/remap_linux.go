@@ -0,0 +1,243 @@
+package idtools
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+	xattrCapability = "security.capability"
+
+	// ACL entry tags naming a user or group by ID
+	aclUser  = 0x02
+	aclGroup = 0x08
+
+	// header and entry sizes of the POSIX ACL xattr format
+	aclHeaderSize = 4
+	aclEntrySize  = 8
+
+	// namespaced file capabilities record the host UID of the namespace's
+	// root at the end of the xattr
+	vfsCapRevisionMask = 0xFF000000
+	vfsCapRevision3    = 0x03000000
+	vfsCapV3Size       = 24
+)
+
+// remappedFile holds the ownership metadata of a file, translated from one
+// mapping to another
+type remappedFile struct {
+	owner, newOwner IDPair
+	mode            uint32
+	acls            map[string][]byte
+	capability      []byte
+}
+
+// RemapOwnership walks the tree at root and changes the owner of every file
+// from its host IDs under the from mappings to the host IDs of the same
+// container IDs under the to mappings, such as when moving a data directory
+// from one remap user to another. Empty mappings are the host's identity
+// mapping. Symbolic links are changed themselves rather than followed. The
+// setuid and setgid bits and file capabilities, which the kernel drops on
+// chown, are restored, and the users and groups named in ACLs are remapped as
+// well. Files with any ID which cannot be mapped are left unchanged and
+// listed in the report; any other failure to read or change a file ends the
+// walk with an error.
+func RemapOwnership(root string, from, to *IDMappings, opts RemapOptions) (*RemapReport, error) {
+	report := &RemapReport{}
+	type inode struct{ dev, ino uint64 }
+	seen := map[inode]bool{}
+
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("Cannot read the owner of %s", path)
+		}
+		// hard links are only remapped once
+		if st.Nlink > 1 && !fi.IsDir() {
+			key := inode{dev: uint64(st.Dev), ino: st.Ino}
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		f, err := remapFile(path, fi, st, from, to)
+		if isUnmapped(err) {
+			report.Unmapped = append(report.Unmapped, UnmappedFile{Path: path, Err: err})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return nil
+		}
+		report.Changed++
+		if opts.Changed != nil {
+			opts.Changed(path, f.owner, f.newOwner)
+		}
+		if opts.DryRun {
+			return nil
+		}
+		return f.apply(path, fi)
+	})
+	return report, err
+}
+
+// remapFile reads the ownership metadata of a file and translates it,
+// returning nil if nothing changes
+func remapFile(path string, fi os.FileInfo, st *syscall.Stat_t, from, to *IDMappings) (*remappedFile, error) {
+	f := &remappedFile{
+		owner: IDPair{UID: int(st.Uid), GID: int(st.Gid)},
+		mode:  st.Mode & 07777,
+		acls:  map[string][]byte{},
+	}
+	var err error
+	if f.newOwner.UID, err = remapID(f.owner.UID, from.uids, to.uids); err != nil {
+		return nil, err
+	}
+	if f.newOwner.GID, err = remapID(f.owner.GID, from.gids, to.gids); err != nil {
+		return nil, err
+	}
+	changed := f.newOwner != f.owner
+
+	// symbolic links have neither ACLs nor capabilities
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if !changed {
+			return nil, nil
+		}
+		return f, nil
+	}
+	for _, name := range []string{xattrACLAccess, xattrACLDefault} {
+		acl, err := getXattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if acl == nil {
+			continue
+		}
+		remapped, aclChanged, err := remapACL(acl, from, to)
+		if isUnmapped(err) {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "read " + name, Path: path, Err: err}
+		}
+		if aclChanged {
+			f.acls[name] = remapped
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	if f.capability, err = getXattr(path, xattrCapability); err != nil {
+		return nil, err
+	}
+	if len(f.capability) == vfsCapV3Size &&
+		binary.LittleEndian.Uint32(f.capability)&vfsCapRevisionMask == vfsCapRevision3 {
+		rootID, err := remapID(int(binary.LittleEndian.Uint32(f.capability[20:])), from.uids, to.uids)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", xattrCapability, err)
+		}
+		binary.LittleEndian.PutUint32(f.capability[20:], uint32(rootID))
+	}
+	return f, nil
+}
+
+// apply changes the owner of the file, restoring what chown drops, and
+// writes its remapped ACLs
+func (f *remappedFile) apply(path string, fi os.FileInfo) error {
+	if f.newOwner != f.owner {
+		if err := os.Lchown(path, f.newOwner.UID, f.newOwner.GID); err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if f.capability != nil {
+			if err := unix.Lsetxattr(path, xattrCapability, f.capability, 0); err != nil {
+				return &os.PathError{Op: "setxattr " + xattrCapability, Path: path, Err: err}
+			}
+		}
+		if f.mode&(unix.S_ISUID|unix.S_ISGID) != 0 {
+			if err := unix.Chmod(path, f.mode); err != nil {
+				return &os.PathError{Op: "chmod", Path: path, Err: err}
+			}
+		}
+	}
+	for name, acl := range f.acls {
+		if err := unix.Lsetxattr(path, name, acl, 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// remapACL translates the users and groups named by the entries of an ACL in
+// the POSIX ACL xattr format
+func remapACL(acl []byte, from, to *IDMappings) ([]byte, bool, error) {
+	if len(acl) < aclHeaderSize || (len(acl)-aclHeaderSize)%aclEntrySize != 0 {
+		return nil, false, fmt.Errorf("invalid ACL of %d bytes", len(acl))
+	}
+	remapped := make([]byte, len(acl))
+	copy(remapped, acl)
+	changed := false
+	for off := aclHeaderSize; off < len(remapped); off += aclEntrySize {
+		var idMapFrom, idMapTo []IDMap
+		switch binary.LittleEndian.Uint16(remapped[off:]) {
+		case aclUser:
+			idMapFrom, idMapTo = from.uids, to.uids
+		case aclGroup:
+			idMapFrom, idMapTo = from.gids, to.gids
+		default:
+			continue
+		}
+		id := int(binary.LittleEndian.Uint32(remapped[off+4:]))
+		newID, err := remapID(id, idMapFrom, idMapTo)
+		if err != nil {
+			return nil, false, err
+		}
+		if newID != id {
+			binary.LittleEndian.PutUint32(remapped[off+4:], uint32(newID))
+			changed = true
+		}
+	}
+	return remapped, changed, nil
+}
+
+// getXattr returns the value of an extended attribute of path, not following
+// symbolic links, or nil if it is not set or not supported
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			if err == unix.ENODATA || err == unix.ENOTSUP {
+				return nil, nil
+			}
+			return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, buf)
+		if err == unix.ERANGE {
+			// the value grew since its size was read
+			continue
+		}
+		if err != nil {
+			if err == unix.ENODATA {
+				return nil, nil
+			}
+			return nil, &os.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,223 @@
+package idtools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// aclEntry is an entry of an ACL in the POSIX ACL xattr format
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// ACL entry tags not naming an ID
+const (
+	aclUserObj  = 0x01
+	aclGroupObj = 0x04
+	aclMask     = 0x10
+	aclOther    = 0x20
+	aclUndefID  = 0xFFFFFFFF
+)
+
+func encodeACL(entries ...aclEntry) []byte {
+	buf := make([]byte, aclHeaderSize, aclHeaderSize+len(entries)*aclEntrySize)
+	binary.LittleEndian.PutUint32(buf, 2)
+	for _, e := range entries {
+		var b [aclEntrySize]byte
+		binary.LittleEndian.PutUint16(b[0:], e.tag)
+		binary.LittleEndian.PutUint16(b[2:], e.perm)
+		binary.LittleEndian.PutUint32(b[4:], e.id)
+		buf = append(buf, b[:]...)
+	}
+	return buf
+}
+
+// namedACL returns a valid access ACL granting read access to a user and a
+// group
+func namedACL(uid, gid uint32) []byte {
+	return encodeACL(
+		aclEntry{aclUserObj, 6, aclUndefID},
+		aclEntry{aclUser, 4, uid},
+		aclEntry{aclGroupObj, 4, aclUndefID},
+		aclEntry{aclGroup, 4, gid},
+		aclEntry{aclMask, 4, aclUndefID},
+		aclEntry{aclOther, 4, aclUndefID},
+	)
+}
+
+func testMappings() (*IDMappings, *IDMappings) {
+	from := NewIDMappingsFromMaps(
+		[]IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		[]IDMap{{ContainerID: 0, HostID: 300000, Size: 65536}},
+	)
+	to := NewIDMappingsFromMaps(
+		[]IDMap{{ContainerID: 0, HostID: 200000, Size: 65536}},
+		[]IDMap{{ContainerID: 0, HostID: 400000, Size: 65536}},
+	)
+	return from, to
+}
+
+func TestRemapACL(t *testing.T) {
+	from, to := testMappings()
+	for _, tc := range []struct {
+		name     string
+		acl      []byte
+		remapped []byte
+		changed  bool
+		unmapped bool
+	}{
+		{"named user and group", namedACL(100005, 300007), namedACL(200005, 400007), true, false},
+		{"already remapped", namedACL(200005, 400007), nil, false, true},
+		{"unmapped group", namedACL(100005, 1000), nil, false, true},
+		{"owner entries only", encodeACL(
+			aclEntry{aclUserObj, 6, aclUndefID},
+			aclEntry{aclGroupObj, 4, aclUndefID},
+			aclEntry{aclOther, 4, aclUndefID},
+		), encodeACL(
+			aclEntry{aclUserObj, 6, aclUndefID},
+			aclEntry{aclGroupObj, 4, aclUndefID},
+			aclEntry{aclOther, 4, aclUndefID},
+		), false, false},
+		{"header only", encodeACL(), encodeACL(), false, false},
+		{"truncated", namedACL(100005, 300007)[:10], nil, false, false},
+		{"empty", []byte{}, nil, false, false},
+	} {
+		remapped, changed, err := remapACL(tc.acl, from, to)
+		if tc.remapped == nil {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			} else if isUnmapped(err) != tc.unmapped {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if changed != tc.changed || !bytes.Equal(remapped, tc.remapped) {
+			t.Errorf("%s: got %x (changed %t), expected %x", tc.name, remapped, changed, tc.remapped)
+		}
+		if changed && bytes.Equal(remapped, tc.acl) {
+			t.Errorf("%s: the original ACL was modified", tc.name)
+		}
+	}
+}
+
+// capabilityV3 returns a namespaced file capability xattr granting
+// CAP_NET_BIND_SERVICE to the root of the namespace whose root is rootID
+func capabilityV3(rootID uint32) []byte {
+	c := make([]byte, vfsCapV3Size)
+	binary.LittleEndian.PutUint32(c[0:], vfsCapRevision3|1) // effective
+	binary.LittleEndian.PutUint32(c[4:], 1<<unix.CAP_NET_BIND_SERVICE)
+	binary.LittleEndian.PutUint32(c[20:], rootID)
+	return c
+}
+
+func TestRemapOwnership(t *testing.T) {
+	requireRoot(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	from, to := testMappings()
+
+	write := func(name string, uid, gid int) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	if err := os.Chown(dir, 100000, 300000); err != nil {
+		t.Fatal(err)
+	}
+	plain := write("plain", 100000, 300000)
+	setuid := write("setuid", 100000, 300000)
+	if err := os.Chmod(setuid, os.ModeSetuid|0755); err != nil {
+		t.Fatal(err)
+	}
+	capable := write("capable", 100000, 300000)
+	if err := unix.Lsetxattr(capable, xattrCapability, capabilityV3(100000), 0); err != nil {
+		t.Skipf("file capabilities are not supported: %v", err)
+	}
+	acl := write("acl", 100001, 300001)
+	withACL := true
+	if err := unix.Lsetxattr(acl, xattrACLAccess, namedACL(100005, 300007), 0); err != nil {
+		t.Logf("ACLs are not supported: %v", err)
+		withACL = false
+	}
+	unmapped := write("unmapped", 1000, 300000)
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("unmapped", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Lchown(link, 100002, 300002); err != nil {
+		t.Fatal(err)
+	}
+
+	// a dry run changes nothing
+	report, err := RemapOwnership(dir, from, to, RemapOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Changed != 6 || len(report.Unmapped) != 1 || report.Unmapped[0].Path != unmapped {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	assertOwner(t, plain, 100000, 300000)
+
+	report, err = RemapOwnership(dir, from, to, RemapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Changed != 6 || len(report.Unmapped) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	assertOwner(t, dir, 200000, 400000)
+	assertOwner(t, plain, 200000, 400000)
+	assertOwner(t, unmapped, 1000, 300000)
+
+	var st unix.Stat_t
+	if err := unix.Lstat(link, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Uid != 200002 || st.Gid != 400002 {
+		t.Errorf("%s is owned by %d:%d, expected 200002:400002", link, st.Uid, st.Gid)
+	}
+	if err := unix.Stat(setuid, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode&07777 != 04755 {
+		t.Errorf("%s has mode %o, expected the setuid bit to be kept", setuid, st.Mode&07777)
+	}
+	if c, err := getXattr(capable, xattrCapability); err != nil || !bytes.Equal(c, capabilityV3(200000)) {
+		t.Errorf("%s has capability %x (%v), expected %x", capable, c, err, capabilityV3(200000))
+	}
+	if withACL {
+		if a, err := getXattr(acl, xattrACLAccess); err != nil || !bytes.Equal(a, namedACL(200005, 400007)) {
+			t.Errorf("%s has ACL %x (%v), expected %x", acl, a, err, namedACL(200005, 400007))
+		}
+	}
+
+	// an unmapped capability root leaves the file unchanged and reported
+	if err := unix.Lsetxattr(capable, xattrCapability, capabilityV3(5000), 0); err != nil {
+		t.Fatal(err)
+	}
+	report, err = RemapOwnership(capable, to, from, RemapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Changed != 0 || len(report.Unmapped) != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	assertOwner(t, capable, 200000, 400000)
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package idtools
+
+import "fmt"
+
+// RemapOwnership is not supported on this OS
+func RemapOwnership(root string, from, to *IDMappings, opts RemapOptions) (*RemapReport, error) {
+	return nil, fmt.Errorf("No support for remapping file ownership on this OS")
+}
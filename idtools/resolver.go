@@ -0,0 +1,331 @@
+package idtools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/opencontainers/runc/libcontainer/user"
+)
+
+// Resolver looks up users and groups in a user and group database. Lookups
+// of entries the database does not hold fail with an *UnknownUserError or
+// *UnknownGroupError; failures to query the database itself are reported as
+// a *DatabaseError.
+type Resolver interface {
+	LookupUser(name string) (user.User, error)
+	LookupUID(uid int) (user.User, error)
+	LookupGroup(name string) (user.Group, error)
+	LookupGID(gid int) (user.Group, error)
+}
+
+// UnknownUserError is returned when a user name or UID is not in a database
+type UnknownUserError struct {
+	User string
+}
+
+func (e *UnknownUserError) Error() string {
+	return fmt.Sprintf("No such user: %s", e.User)
+}
+
+// UnknownGroupError is returned when a group name or GID is not in a database
+type UnknownGroupError struct {
+	Group string
+}
+
+func (e *UnknownGroupError) Error() string {
+	return fmt.Sprintf("No such group: %s", e.Group)
+}
+
+// DatabaseError is returned when a user and group database cannot be queried
+type DatabaseError struct {
+	Source string
+	Err    error
+}
+
+func (e *DatabaseError) Error() string {
+	return fmt.Sprintf("Cannot query %s: %v", e.Source, e.Err)
+}
+
+func (e *DatabaseError) Unwrap() error {
+	return e.Err
+}
+
+// IsUnknown reports whether err reports a user or group which is not in the
+// database
+func IsUnknown(err error) bool {
+	var (
+		uerr *UnknownUserError
+		gerr *UnknownGroupError
+	)
+	return errors.As(err, &uerr) || errors.As(err, &gerr)
+}
+
+// FilesResolver looks up users and groups in the passwd and group files under
+// Root, such as the root filesystem of an image, or of the host if Root is
+// empty. Symbolic links are resolved within Root. A missing file is treated
+// as an empty database.
+type FilesResolver struct {
+	Root string
+}
+
+// LookupUser returns the user with the given name
+func (r *FilesResolver) LookupUser(name string) (user.User, error) {
+	return r.lookupUser(name, func(u user.User) bool { return u.Name == name })
+}
+
+// LookupUID returns the user with the given UID
+func (r *FilesResolver) LookupUID(uid int) (user.User, error) {
+	return r.lookupUser(strconv.Itoa(uid), func(u user.User) bool { return u.Uid == uid })
+}
+
+// LookupGroup returns the group with the given name
+func (r *FilesResolver) LookupGroup(name string) (user.Group, error) {
+	return r.lookupGroup(name, func(g user.Group) bool { return g.Name == name })
+}
+
+// LookupGID returns the group with the given GID
+func (r *FilesResolver) LookupGID(gid int) (user.Group, error) {
+	return r.lookupGroup(strconv.Itoa(gid), func(g user.Group) bool { return g.Gid == gid })
+}
+
+func (r *FilesResolver) lookupUser(key string, filter func(user.User) bool) (user.User, error) {
+	path, err := r.path("/etc/passwd")
+	if err != nil {
+		return user.User{}, err
+	}
+	users, err := user.ParsePasswdFileFilter(path, filter)
+	if err != nil && !os.IsNotExist(err) {
+		return user.User{}, &DatabaseError{Source: path, Err: err}
+	}
+	if len(users) == 0 {
+		return user.User{}, &UnknownUserError{User: key}
+	}
+	return users[0], nil
+}
+
+func (r *FilesResolver) lookupGroup(key string, filter func(user.Group) bool) (user.Group, error) {
+	path, err := r.path("/etc/group")
+	if err != nil {
+		return user.Group{}, err
+	}
+	groups, err := user.ParseGroupFileFilter(path, filter)
+	if err != nil && !os.IsNotExist(err) {
+		return user.Group{}, &DatabaseError{Source: path, Err: err}
+	}
+	if len(groups) == 0 {
+		return user.Group{}, &UnknownGroupError{Group: key}
+	}
+	return groups[0], nil
+}
+
+// path returns the path of a database file, resolved within the root
+func (r *FilesResolver) path(name string) (string, error) {
+	if r.Root == "" {
+		return name, nil
+	}
+	path, err := fs.RootPath(r.Root, name)
+	if err != nil {
+		return "", &DatabaseError{Source: filepath.Join(r.Root, name), Err: err}
+	}
+	return path, nil
+}
+
+// StaticResolver looks up users and groups in fixed lists
+type StaticResolver struct {
+	Users  []user.User
+	Groups []user.Group
+}
+
+// LookupUser returns the user with the given name
+func (r *StaticResolver) LookupUser(name string) (user.User, error) {
+	for _, u := range r.Users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return user.User{}, &UnknownUserError{User: name}
+}
+
+// LookupUID returns the user with the given UID
+func (r *StaticResolver) LookupUID(uid int) (user.User, error) {
+	for _, u := range r.Users {
+		if u.Uid == uid {
+			return u, nil
+		}
+	}
+	return user.User{}, &UnknownUserError{User: strconv.Itoa(uid)}
+}
+
+// LookupGroup returns the group with the given name
+func (r *StaticResolver) LookupGroup(name string) (user.Group, error) {
+	for _, g := range r.Groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return user.Group{}, &UnknownGroupError{Group: name}
+}
+
+// LookupGID returns the group with the given GID
+func (r *StaticResolver) LookupGID(gid int) (user.Group, error) {
+	for _, g := range r.Groups {
+		if g.Gid == gid {
+			return g, nil
+		}
+	}
+	return user.Group{}, &UnknownGroupError{Group: strconv.Itoa(gid)}
+}
+
+// ChainResolver tries each of its resolvers in turn while they report the
+// entry unknown, returning the first entry found. A resolver failing with any
+// other error ends the lookup with that error, so that a database which
+// cannot be queried is not masked by the next one.
+type ChainResolver []Resolver
+
+// LookupUser returns the user with the given name
+func (r ChainResolver) LookupUser(name string) (user.User, error) {
+	v, err := r.lookup(func(res Resolver) (interface{}, error) { return res.LookupUser(name) })
+	if err != nil {
+		return user.User{}, err
+	}
+	return v.(user.User), nil
+}
+
+// LookupUID returns the user with the given UID
+func (r ChainResolver) LookupUID(uid int) (user.User, error) {
+	v, err := r.lookup(func(res Resolver) (interface{}, error) { return res.LookupUID(uid) })
+	if err != nil {
+		return user.User{}, err
+	}
+	return v.(user.User), nil
+}
+
+// LookupGroup returns the group with the given name
+func (r ChainResolver) LookupGroup(name string) (user.Group, error) {
+	v, err := r.lookup(func(res Resolver) (interface{}, error) { return res.LookupGroup(name) })
+	if err != nil {
+		return user.Group{}, err
+	}
+	return v.(user.Group), nil
+}
+
+// LookupGID returns the group with the given GID
+func (r ChainResolver) LookupGID(gid int) (user.Group, error) {
+	v, err := r.lookup(func(res Resolver) (interface{}, error) { return res.LookupGID(gid) })
+	if err != nil {
+		return user.Group{}, err
+	}
+	return v.(user.Group), nil
+}
+
+func (r ChainResolver) lookup(fn func(Resolver) (interface{}, error)) (interface{}, error) {
+	var unknownErr error
+	for _, res := range r {
+		v, err := fn(res)
+		if err == nil {
+			return v, nil
+		}
+		if !IsUnknown(err) {
+			return nil, err
+		}
+		if unknownErr == nil {
+			unknownErr = err
+		}
+	}
+	if unknownErr == nil {
+		return nil, &DatabaseError{Source: "user and group database", Err: errors.New("no resolvers configured")}
+	}
+	return nil, unknownErr
+}
+
+// CachingResolver remembers the entries found by another resolver, as well
+// as the ones it reported unknown, for TTL. Database errors are not cached.
+type CachingResolver struct {
+	Resolver Resolver
+	TTL      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// NewCachingResolver returns a resolver caching the lookups of r for ttl
+func NewCachingResolver(r Resolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{Resolver: r, TTL: ttl}
+}
+
+// LookupUser returns the user with the given name
+func (r *CachingResolver) LookupUser(name string) (user.User, error) {
+	v, err := r.lookup("user:"+name, func() (interface{}, error) { return r.Resolver.LookupUser(name) })
+	if err != nil {
+		return user.User{}, err
+	}
+	return v.(user.User), nil
+}
+
+// LookupUID returns the user with the given UID
+func (r *CachingResolver) LookupUID(uid int) (user.User, error) {
+	v, err := r.lookup("uid:"+strconv.Itoa(uid), func() (interface{}, error) { return r.Resolver.LookupUID(uid) })
+	if err != nil {
+		return user.User{}, err
+	}
+	return v.(user.User), nil
+}
+
+// LookupGroup returns the group with the given name
+func (r *CachingResolver) LookupGroup(name string) (user.Group, error) {
+	v, err := r.lookup("group:"+name, func() (interface{}, error) { return r.Resolver.LookupGroup(name) })
+	if err != nil {
+		return user.Group{}, err
+	}
+	return v.(user.Group), nil
+}
+
+// LookupGID returns the group with the given GID
+func (r *CachingResolver) LookupGID(gid int) (user.Group, error) {
+	v, err := r.lookup("gid:"+strconv.Itoa(gid), func() (interface{}, error) { return r.Resolver.LookupGID(gid) })
+	if err != nil {
+		return user.Group{}, err
+	}
+	return v.(user.Group), nil
+}
+
+// Flush drops all cached entries, such as after the database was changed
+func (r *CachingResolver) Flush() {
+	r.mu.Lock()
+	r.entries = nil
+	r.mu.Unlock()
+}
+
+func (r *CachingResolver) lookup(key string, fn func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok && now.Before(e.expires) {
+		r.mu.Unlock()
+		return e.value, e.err
+	}
+	r.mu.Unlock()
+
+	v, err := fn()
+	if err != nil && !IsUnknown(err) {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = map[string]cacheEntry{}
+	}
+	r.entries[key] = cacheEntry{value: v, err: err, expires: now.Add(r.TTL)}
+	r.mu.Unlock()
+	return v, err
+}
@@ -0,0 +1,166 @@
+// +build !windows
+
+package idtools
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+)
+
+// countingResolver counts the lookups passed on to its resolver
+type countingResolver struct {
+	Resolver
+	lookups int
+}
+
+func (r *countingResolver) LookupUser(name string) (user.User, error) {
+	r.lookups++
+	return r.Resolver.LookupUser(name)
+}
+
+// failingResolver fails every lookup as if its database could not be queried
+type failingResolver struct {
+	StaticResolver
+}
+
+func (r *failingResolver) LookupUser(name string) (user.User, error) {
+	return user.User{}, &DatabaseError{Source: "test", Err: errors.New("unavailable")}
+}
+
+func TestChainResolver(t *testing.T) {
+	bob := user.User{Name: "bob", Uid: 1000, Gid: 1000}
+	found := &countingResolver{Resolver: &StaticResolver{Users: []user.User{bob}}}
+	for _, tc := range []struct {
+		name    string
+		chain   ChainResolver
+		user    string
+		unknown bool
+		lookups int
+	}{
+		{"found first", ChainResolver{found, &StaticResolver{}}, "bob", false, 1},
+		{"unknown falls through", ChainResolver{&StaticResolver{}, found}, "bob", false, 1},
+		{"unknown everywhere", ChainResolver{&StaticResolver{}, found}, "alice", true, 1},
+		{"database error ends the chain", ChainResolver{&failingResolver{}, found}, "bob", false, 0},
+		{"database error after unknown", ChainResolver{found, &failingResolver{}}, "alice", false, 1},
+		{"no resolvers", ChainResolver{}, "bob", false, 0},
+	} {
+		found.lookups = 0
+		u, err := tc.chain.LookupUser(tc.user)
+		switch {
+		case tc.lookups == 1 && !tc.unknown && err == nil:
+			if u != bob {
+				t.Errorf("%s: got %+v", tc.name, u)
+			}
+		case tc.unknown:
+			var uerr *UnknownUserError
+			if !errors.As(err, &uerr) || uerr.User != tc.user {
+				t.Errorf("%s: expected an unknown user error, got %v", tc.name, err)
+			}
+		default:
+			var derr *DatabaseError
+			if !errors.As(err, &derr) {
+				t.Errorf("%s: expected a database error, got %v", tc.name, err)
+			}
+		}
+		if found.lookups != tc.lookups {
+			t.Errorf("%s: %d lookups reached the last resolver, expected %d", tc.name, found.lookups, tc.lookups)
+		}
+	}
+}
+
+func TestCachingResolver(t *testing.T) {
+	counting := &countingResolver{Resolver: &StaticResolver{Users: []user.User{{Name: "bob", Uid: 1000}}}}
+	r := NewCachingResolver(counting, 100*time.Millisecond)
+
+	for _, name := range []string{"bob", "bob", "alice", "alice"} {
+		r.LookupUser(name)
+	}
+	if counting.lookups != 2 {
+		t.Errorf("expected found and unknown users to be cached, got %d lookups", counting.lookups)
+	}
+	if _, err := r.LookupUser("alice"); !IsUnknown(err) {
+		t.Errorf("expected the cached lookup to fail as unknown, got %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if u, err := r.LookupUser("bob"); err != nil || u.Uid != 1000 {
+		t.Errorf("got %+v, %v", u, err)
+	}
+	if counting.lookups != 3 {
+		t.Errorf("expected an expired entry to be looked up again, got %d lookups", counting.lookups)
+	}
+	r.Flush()
+	r.LookupUser("bob")
+	if counting.lookups != 4 {
+		t.Errorf("expected a flushed entry to be looked up again, got %d lookups", counting.lookups)
+	}
+
+	failing := &countingResolver{Resolver: &failingResolver{}}
+	r = NewCachingResolver(failing, time.Hour)
+	r.LookupUser("bob")
+	r.LookupUser("bob")
+	if failing.lookups != 2 {
+		t.Errorf("expected database errors not to be cached, got %d lookups", failing.lookups)
+	}
+}
+
+func TestFilesResolver(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\n# a comment\nbob:x:1000:1000:Bob:/home/bob:/bin/sh\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "etc", "passwd.real"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// an absolute link is resolved within the root
+	if err := os.Symlink("/etc/passwd.real", filepath.Join(root, "etc", "passwd")); err != nil {
+		t.Fatal(err)
+	}
+	group := "root:x:0:\nstaff:x:50:bob,alice\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "etc", "group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &FilesResolver{Root: root}
+	if u, err := r.LookupUser("bob"); err != nil || u.Uid != 1000 || u.Home != "/home/bob" {
+		t.Errorf("LookupUser(bob) = %+v, %v", u, err)
+	}
+	if u, err := r.LookupUID(0); err != nil || u.Name != "root" {
+		t.Errorf("LookupUID(0) = %+v, %v", u, err)
+	}
+	if g, err := r.LookupGroup("staff"); err != nil || g.Gid != 50 || len(g.List) != 2 {
+		t.Errorf("LookupGroup(staff) = %+v, %v", g, err)
+	}
+	if g, err := r.LookupGID(50); err != nil || g.Name != "staff" {
+		t.Errorf("LookupGID(50) = %+v, %v", g, err)
+	}
+	if _, err := r.LookupUser("alice"); !IsUnknown(err) {
+		t.Errorf("expected alice to be unknown, got %v", err)
+	}
+	if _, err := r.LookupGID(1000); !IsUnknown(err) {
+		t.Errorf("expected GID 1000 to be unknown, got %v", err)
+	}
+
+	// a missing file is an empty database, one which cannot be resolved an error
+	if err := os.Remove(filepath.Join(root, "etc", "group")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.LookupGroup("staff"); !IsUnknown(err) {
+		t.Errorf("expected staff to be unknown without a group file, got %v", err)
+	}
+	if err := os.Symlink("group", filepath.Join(root, "etc", "group")); err != nil {
+		t.Fatal(err)
+	}
+	var derr *DatabaseError
+	if _, err := r.LookupGroup("staff"); !errors.As(err, &derr) {
+		t.Errorf("expected a database error for a looping group file, got %v", err)
+	}
+}
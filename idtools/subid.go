@@ -0,0 +1,198 @@
+package idtools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SubIDRange is a range of subordinate IDs delegated to a user or group, as
+// listed in /etc/subuid or /etc/subgid
+type SubIDRange struct {
+	Owner  string `json:"owner"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+func (r SubIDRange) String() string {
+	return fmt.Sprintf("%s:%d:%d", r.Owner, r.Start, r.Length)
+}
+
+// ListSubIDRanges returns the ranges of a subordinate ID file delegated to
+// the owner, or all of them if the owner is "ALL"
+func ListSubIDRanges(path, owner string) ([]SubIDRange, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list []SubIDRange
+	err = forEachSubIDLine(path, data, func(_ string, r *SubIDRange) error {
+		if r != nil && (owner == "ALL" || r.Owner == owner) {
+			list = append(list, *r)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// SubIDFile is a subordinate ID file with all its ranges
+type SubIDFile struct {
+	Path   string
+	Ranges []SubIDRange
+	owners func(string) []string
+}
+
+// Owners returns the owner names under which the ranges of the file of a user
+// or group, given by name or ID, may be listed
+func (f SubIDFile) Owners(name string) []string {
+	return f.owners(name)
+}
+
+// ListSubordinateRanges returns /etc/subuid and /etc/subgid with all their
+// ranges
+func ListSubordinateRanges() (SubIDFile, SubIDFile, error) {
+	subuid := SubIDFile{Path: subuidFileName, owners: subuidOwners}
+	subgid := SubIDFile{Path: subgidFileName, owners: subgidOwners}
+	var err error
+	if subuid.Ranges, err = ListSubIDRanges(subuid.Path, "ALL"); err != nil {
+		return SubIDFile{}, SubIDFile{}, err
+	}
+	if subgid.Ranges, err = ListSubIDRanges(subgid.Path, "ALL"); err != nil {
+		return SubIDFile{}, SubIDFile{}, err
+	}
+	return subuid, subgid, nil
+}
+
+// AddSubIDRange appends a range to a subordinate ID file, creating the file
+// if needed. The range may not overlap any range already in the file.
+func AddSubIDRange(path string, r SubIDRange) error {
+	if r.Owner == "" || strings.ContainsAny(r.Owner, ":\n") {
+		return fmt.Errorf("Invalid subordinate ID range owner %q", r.Owner)
+	}
+	if r.Start < 0 || r.Length <= 0 {
+		return fmt.Errorf("Invalid subordinate ID range %s", r)
+	}
+	return editSubIDFile(path, func(lines []string, existing []SubIDRange) ([]string, error) {
+		for _, e := range existing {
+			if r.Start < e.Start+e.Length && e.Start < r.Start+r.Length {
+				return nil, fmt.Errorf("Range %s overlaps range %s in %s", r, e, path)
+			}
+		}
+		return append(lines, r.String()), nil
+	})
+}
+
+// RemoveSubIDRanges removes the ranges of a subordinate ID file delegated to
+// the owner; if start is not negative, only the range starting at start is
+// removed. It returns the number of ranges removed.
+func RemoveSubIDRanges(path, owner string, start int) (int, error) {
+	removed := 0
+	err := editSubIDFile(path, func(lines []string, _ []SubIDRange) ([]string, error) {
+		var kept []string
+		for _, line := range lines {
+			r, err := parseSubIDLine(path, line)
+			if err != nil {
+				return nil, err
+			}
+			if r != nil && r.Owner == owner && (start < 0 || r.Start == start) {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return kept, nil
+	})
+	return removed, err
+}
+
+// editSubIDFile rewrites a subordinate ID file with the lines returned by
+// edit, which is given the current lines, including comments, and the ranges
+// they hold. The file is locked like shadow-utils does during the edit and
+// replaced atomically, keeping its permissions.
+func editSubIDFile(path string, edit func([]string, []SubIDRange) ([]string, error)) error {
+	unlock, err := lockShadowFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot lock %s: %v", path, err)
+	}
+	defer unlock()
+
+	perm := os.FileMode(0644)
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		perm = fi.Mode().Perm()
+	case !os.IsNotExist(err):
+		return err
+	}
+	var (
+		lines    []string
+		existing []SubIDRange
+	)
+	err = forEachSubIDLine(path, data, func(line string, r *SubIDRange) error {
+		lines = append(lines, line)
+		if r != nil {
+			existing = append(existing, *r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if lines, err = edit(lines, existing); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return writeFileAtomic(path, buf.Bytes(), perm)
+}
+
+// forEachSubIDLine calls fn with each line of a subordinate ID file and the
+// range it holds, which is nil for blank lines and comments
+func forEachSubIDLine(path string, data []byte, fn func(string, *SubIDRange) error) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		r, err := parseSubIDLine(path, s.Text())
+		if err != nil {
+			return err
+		}
+		if err := fn(s.Text(), r); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// parseSubIDLine parses an owner:start:length line of a subordinate ID file
+func parseSubIDLine(path, line string) (*SubIDRange, error) {
+	text := strings.TrimSpace(line)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return nil, nil
+	}
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Cannot parse subuid/gid information: Format not correct for %s file", path)
+	}
+	start, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("String to int conversion failed during subuid/gid parsing of %s: %v", path, err)
+	}
+	length, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("String to int conversion failed during subuid/gid parsing of %s: %v", path, err)
+	}
+	return &SubIDRange{Owner: parts[0], Start: start, Length: length}, nil
+}
@@ -0,0 +1,184 @@
+// +build !windows
+
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+)
+
+func TestEditSubIDRanges(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subuid")
+	if err := ioutil.WriteFile(path, []byte("# delegated ranges\nbob:100000:65536\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []SubIDRange{
+		{Owner: "alice", Start: 165536, Length: 65536},
+		{Owner: "1000", Start: 231072, Length: 1000},
+		{Owner: "bob", Start: 300000, Length: 10},
+	} {
+		if err := AddSubIDRange(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []SubIDRange{
+		{Owner: "carol", Start: 165000, Length: 1000},
+		{Owner: "carol", Start: 0, Length: 0},
+		{Owner: "ca:rol", Start: 400000, Length: 1},
+		{Owner: "", Start: 400000, Length: 1},
+	} {
+		if err := AddSubIDRange(path, r); err == nil {
+			t.Errorf("expected an error adding %s", r)
+		}
+	}
+
+	for _, tc := range []struct {
+		owner  string
+		ranges []SubIDRange
+	}{
+		{"bob", []SubIDRange{{"bob", 100000, 65536}, {"bob", 300000, 10}}},
+		{"1000", []SubIDRange{{"1000", 231072, 1000}}},
+		{"carol", nil},
+		{"ALL", []SubIDRange{{"bob", 100000, 65536}, {"alice", 165536, 65536}, {"1000", 231072, 1000}, {"bob", 300000, 10}}},
+	} {
+		ranges, err := ListSubIDRanges(path, tc.owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ranges, tc.ranges) {
+			t.Errorf("ranges of %s: got %v, expected %v", tc.owner, ranges, tc.ranges)
+		}
+	}
+
+	if n, err := RemoveSubIDRanges(path, "bob", 300000); err != nil || n != 1 {
+		t.Errorf("removing one range: removed %d, %v", n, err)
+	}
+	if n, err := RemoveSubIDRanges(path, "alice", -1); err != nil || n != 1 {
+		t.Errorf("removing all ranges: removed %d, %v", n, err)
+	}
+	if n, err := RemoveSubIDRanges(path, "carol", -1); err != nil || n != 0 {
+		t.Errorf("removing no ranges: removed %d, %v", n, err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# delegated ranges\nbob:100000:65536\n1000:231072:1000\n"; string(data) != expected {
+		t.Errorf("got file %q, expected %q", data, expected)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("file mode changed to %o", fi.Mode().Perm())
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed, got %v", err)
+	}
+
+	// a missing file is created
+	created := filepath.Join(dir, "subgid")
+	if ranges, err := ListSubIDRanges(created, "ALL"); err != nil || ranges != nil {
+		t.Errorf("listing a missing file: %v, %v", ranges, err)
+	}
+	if err := AddSubIDRange(created, SubIDRange{Owner: "bob", Start: 100000, Length: 65536}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockShadowFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subuid")
+	lock := path + ".lock"
+
+	// the lock of a process which is gone, above the largest possible pid,
+	// is taken over, and our own lock can be taken again once released
+	if err := ioutil.WriteFile(lock, []byte(strconv.Itoa(1<<22+1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		unlock, err := lockShadowFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(lock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != strconv.Itoa(os.Getpid()) {
+			t.Errorf("lock file holds %q, expected our pid", data)
+		}
+		if err := unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed, got %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	// the lock of a running process and a lock file without a pid are kept
+	for _, content := range []string{strconv.Itoa(os.Getppid()), "", "garbage"} {
+		if err := ioutil.WriteFile(lock, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if removeStaleLock(lock) {
+			t.Errorf("lock file %q was removed", content)
+		}
+	}
+}
+
+func TestSubIDOwners(t *testing.T) {
+	defer SetResolver(getResolver())
+	SetResolver(&StaticResolver{
+		Users:  []user.User{{Name: "bob", Uid: 1000, Gid: 1001}},
+		Groups: []user.Group{{Name: "bob", Gid: 1001}, {Name: "staff", Gid: 50}},
+	})
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subid")
+	content := "bob:100000:1000\n1000:200000:1000\nstaff:300000:1000\n50:400000:1000\nalice:500000:1000\n1001:600000:1000\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		owners []string
+		ranges ranges
+	}{
+		{"user by name", subuidOwners("bob"), ranges{{100000, 1000}, {200000, 1000}}},
+		{"user by UID", subuidOwners("1000"), ranges{{100000, 1000}, {200000, 1000}}},
+		{"group by name", subgidOwners("staff"), ranges{{300000, 1000}, {400000, 1000}}},
+		{"group by GID", subgidOwners("50"), ranges{{300000, 1000}, {400000, 1000}}},
+		// shadow-utils lists subordinate GIDs under the user's name or UID
+		{"user's group by name", subgidOwners("bob"), ranges{{100000, 1000}, {200000, 1000}, {600000, 1000}}},
+		{"user's group by GID", subgidOwners("1001"), ranges{{100000, 1000}, {600000, 1000}}},
+		{"user's GIDs by UID", subgidOwners("1000"), ranges{{100000, 1000}, {200000, 1000}}},
+		{"unknown user", subuidOwners("alice"), ranges{{500000, 1000}}},
+		{"unknown UID", subuidOwners("2000"), nil},
+		{"all", []string{"ALL"}, ranges{{100000, 1000}, {200000, 1000}, {300000, 1000}, {400000, 1000}, {500000, 1000}, {600000, 1000}}},
+	} {
+		rangeList, err := parseSubidFile(path, tc.owners...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rangeList, tc.ranges) {
+			t.Errorf("%s: got %v, expected %v", tc.name, rangeList, tc.ranges)
+		}
+	}
+}
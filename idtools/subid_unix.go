@@ -0,0 +1,63 @@
+// +build !windows
+
+package idtools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	shadowLockAttempts = 15
+	shadowLockInterval = time.Second
+)
+
+// lockShadowFile takes the lock shadow-utils tools such as usermod use for a
+// file like /etc/subuid: the lock is held by whoever manages to hard link a
+// file holding its pid to <path>.lock. A lock left behind by a process which
+// no longer exists is removed. The returned function releases the lock.
+func lockShadowFile(path string) (func() error, error) {
+	lock := path + ".lock"
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	for i := 0; i < shadowLockAttempts; i++ {
+		err := os.Link(tmp, lock)
+		if err == nil {
+			return func() error { return os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if removeStaleLock(lock) {
+			continue
+		}
+		time.Sleep(shadowLockInterval)
+	}
+	return nil, fmt.Errorf("%s is locked by another process", path)
+}
+
+// removeStaleLock removes a shadow-utils lock file whose process is gone, and
+// reports whether it did. The pid is written before the lock file is linked,
+// so a lock file with anything else in it is left alone, as shadow-utils
+// does.
+func removeStaleLock(lock string) bool {
+	data, err := ioutil.ReadFile(lock)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil || pid <= 0 || unix.Kill(pid, 0) != unix.ESRCH {
+		return false
+	}
+	return os.Remove(lock) == nil
+}
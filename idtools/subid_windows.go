@@ -0,0 +1,11 @@
+// +build windows
+
+package idtools
+
+import "fmt"
+
+// lockShadowFile is not supported on Windows, which has no subordinate ID
+// files
+func lockShadowFile(path string) (func() error, error) {
+	return nil, fmt.Errorf("File locking is not supported on Windows")
+}
@@ -2,6 +2,7 @@ package idtools
 
 import (
 	"fmt"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
@@ -13,6 +14,8 @@ import (
 // Linux distribution commands:
 // adduser --system --shell /bin/false --disabled-login --disabled-password --no-create-home --group <username>
 // useradd -r -s /bin/false <username>
+// or, with the busybox applets of alpine based systems:
+// addgroup -S <username> && adduser -S -D -H -s /bin/false -G <username> <username>
 
 var (
 	once        sync.Once
@@ -22,13 +25,19 @@ var (
 		"adduser": "--system --shell /bin/false --no-create-home --disabled-login --disabled-password --group %s",
 		"useradd": "-r -s /bin/false %s",
 		"usermod": "-%s %d-%d %s",
+		"deluser": "%s",
+		"userdel": "%s",
+
+		// busybox applets
+		"busybox-addgroup": "-S %s",
+		"busybox-adduser":  "-S -D -H -s /bin/false -G %s %s",
+		"busybox-deluser":  "%s",
+		"busybox-delgroup": "%s",
 	}
 
 	idOutRegexp = regexp.MustCompile(`uid=([0-9]+).*gid=([0-9]+)`)
-	// default length for a UID/GID subordinate range
-	defaultRangeLen   = 65536
-	defaultRangeStart = 100000
-	userMod           = "usermod"
+	userMod     = "usermod"
+	busybox     = "busybox"
 )
 
 // AddNamespaceRangesUser takes a username and uses the standard system
@@ -36,6 +45,15 @@ var (
 // /etc/sub{uid,gid} ranges which will be used for user namespace
 // mapping ranges in containers.
 func AddNamespaceRangesUser(name string) (int, int, error) {
+	return AddNamespaceRangesUserWithSize(name, 0)
+}
+
+// AddNamespaceRangesUserWithSize is AddNamespaceRangesUser with ranges of
+// size IDs; a size of 0 uses the default size from /etc/login.defs.
+func AddNamespaceRangesUserWithSize(name string, size int) (int, int, error) {
+	if size < 0 {
+		return -1, -1, fmt.Errorf("Invalid subordinate range size %d", size)
+	}
 	if err := addUser(name); err != nil {
 		return -1, -1, fmt.Errorf("Error adding user %q: %v", name, err)
 	}
@@ -61,12 +79,76 @@ func AddNamespaceRangesUser(name string) (int, int, error) {
 	// Now we need to create the subuid/subgid ranges for our new user/group (system users
 	// do not get auto-created ranges in subuid/subgid)
 
-	if err := createSubordinateRanges(name); err != nil {
+	if err := createSubordinateRanges(name, size); err != nil {
 		return -1, -1, fmt.Errorf("Couldn't create subordinate ID ranges: %v", err)
 	}
 	return uid, gid, nil
 }
 
+// DelNamespaceRangesUser removes the subordinate ID ranges of a user, then
+// the user itself using the standard system utility
+func DelNamespaceRangesUser(name string) error {
+	if _, err := RemoveSubordinateRanges(name); err != nil {
+		return err
+	}
+	var cmd string
+	if _, err := resolveBinary("deluser"); err == nil {
+		cmd = "deluser"
+	} else if _, err := resolveBinary("userdel"); err == nil {
+		cmd = "userdel"
+	} else if _, err := resolveBusyboxApplet("deluser"); err == nil {
+		return delBusyboxUser(name)
+	} else {
+		return fmt.Errorf("Cannot delete user; no userdel/deluser binary found")
+	}
+	out, err := execCmd(cmd, fmt.Sprintf(cmdTemplates[cmd], name))
+	if err != nil {
+		return fmt.Errorf("Failed to delete user with error: %v; output: %q", err, string(out))
+	}
+	flushResolver()
+	return nil
+}
+
+// delBusyboxUser deletes a user with the busybox deluser applet, then the
+// group of the same name addBusyboxUser created if deluser left it behind
+func delBusyboxUser(name string) error {
+	out, err := execCmd("deluser", fmt.Sprintf(cmdTemplates["busybox-deluser"], name))
+	flushResolver()
+	if err != nil {
+		return fmt.Errorf("Failed to delete user with error: %v; output: %q", err, string(out))
+	}
+	if _, err := LookupGroup(name); err != nil {
+		return nil
+	}
+	out, err = execCmd("delgroup", fmt.Sprintf(cmdTemplates["busybox-delgroup"], name))
+	flushResolver()
+	if err != nil {
+		return fmt.Errorf("Failed to delete group with error: %v; output: %q", err, string(out))
+	}
+	return nil
+}
+
+// RemoveSubordinateRanges removes the /etc/subuid ranges of a user and the
+// /etc/subgid ranges of the group of the same name, listed under their names
+// or IDs, and returns the number removed
+func RemoveSubordinateRanges(name string) (int, error) {
+	removed := 0
+	owners := map[string][]string{
+		subuidFileName: subuidOwners(name),
+		subgidFileName: subgidOwners(name),
+	}
+	for _, path := range []string{subuidFileName, subgidFileName} {
+		for _, owner := range owners[path] {
+			n, err := RemoveSubIDRanges(path, owner, -1)
+			if err != nil {
+				return removed, err
+			}
+			removed += n
+		}
+	}
+	return removed, nil
+}
+
 func addUser(userName string) error {
 	once.Do(func() {
 		// set up which commands are used for adding users/groups dependent on distro
@@ -74,91 +156,157 @@ func addUser(userName string) error {
 			userCommand = "adduser"
 		} else if _, err := resolveBinary("useradd"); err == nil {
 			userCommand = "useradd"
+		} else if _, err := resolveBusyboxApplet("adduser"); err == nil {
+			userCommand = busybox
 		}
 	})
 	if userCommand == "" {
 		return fmt.Errorf("Cannot add user; no useradd/adduser binary found")
 	}
+	if userCommand == busybox {
+		return addBusyboxUser(userName)
+	}
 	args := fmt.Sprintf(cmdTemplates[userCommand], userName)
 	out, err := execCmd(userCommand, args)
 	if err != nil {
 		return fmt.Errorf("Failed to add user with error: %v; output: %q", err, string(out))
 	}
+	flushResolver()
 	return nil
 }
 
-func createSubordinateRanges(name string) error {
+// addBusyboxUser adds a system user with the busybox addgroup and adduser
+// applets, whose options differ from those of the Debian adduser script;
+// like `adduser --group`, the user gets a group of the same name
+func addBusyboxUser(userName string) error {
+	out, err := execCmd("addgroup", fmt.Sprintf(cmdTemplates["busybox-addgroup"], userName))
+	if err != nil {
+		return fmt.Errorf("Failed to add group with error: %v; output: %q", err, string(out))
+	}
+	out, err = execCmd("adduser", fmt.Sprintf(cmdTemplates["busybox-adduser"], userName, userName))
+	if err != nil {
+		execCmd("delgroup", fmt.Sprintf(cmdTemplates["busybox-delgroup"], userName))
+		return fmt.Errorf("Failed to add user with error: %v; output: %q", err, string(out))
+	}
+	flushResolver()
+	return nil
+}
+
+// AddSubordinateRanges gives an existing user a subuid and a subgid range of
+// size IDs each, unless the user already has ranges. A size of 0 uses the
+// SUB_UID_COUNT and SUB_GID_COUNT of /etc/login.defs.
+func AddSubordinateRanges(name string, size int) error {
+	if size < 0 {
+		return fmt.Errorf("Invalid subordinate range size %d", size)
+	}
+	return createSubordinateRanges(name, size)
+}
+
+func createSubordinateRanges(name string, size int) error {
 
 	// first, we should verify that ranges weren't automatically created
 	// by the distro tooling
 	ranges, err := parseSubuid(name)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Error while looking for subuid ranges for user %q: %v", name, err)
 	}
 	if len(ranges) == 0 {
 		// no UID ranges; let's create one
-		startID, err := findNextUIDRange()
+		limits := loadSubIDLimits(loginDefsFileName, "SUB_UID")
+		length := rangeSize(limits, size)
+		startID, err := findNextUIDRange(limits, length)
 		if err != nil {
 			return fmt.Errorf("Can't find available subuid range: %v", err)
 		}
-		out, err := execCmd(userMod, fmt.Sprintf(cmdTemplates[userMod], "v", startID, startID+defaultRangeLen-1, name))
-		if err != nil {
-			return fmt.Errorf("Unable to add subuid range to user: %q; output: %s, err: %v", name, out, err)
+		if err := addSubordinateRange(subuidFileName, "v", name, startID, length); err != nil {
+			return fmt.Errorf("Unable to add subuid range to user: %q; %v", name, err)
 		}
 	}
 
 	ranges, err = parseSubgid(name)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Error while looking for subgid ranges for user %q: %v", name, err)
 	}
 	if len(ranges) == 0 {
 		// no GID ranges; let's create one
-		startID, err := findNextGIDRange()
+		limits := loadSubIDLimits(loginDefsFileName, "SUB_GID")
+		length := rangeSize(limits, size)
+		startID, err := findNextGIDRange(limits, length)
 		if err != nil {
 			return fmt.Errorf("Can't find available subgid range: %v", err)
 		}
-		out, err := execCmd(userMod, fmt.Sprintf(cmdTemplates[userMod], "w", startID, startID+defaultRangeLen-1, name))
-		if err != nil {
-			return fmt.Errorf("Unable to add subgid range to user: %q; output: %s, err: %v", name, out, err)
+		if err := addSubordinateRange(subgidFileName, "w", name, startID, length); err != nil {
+			return fmt.Errorf("Unable to add subgid range to user: %q; %v", name, err)
 		}
 	}
 	return nil
 }
 
-func findNextUIDRange() (int, error) {
+// rangeSize returns the requested size of a new range, or the default size
+// from login.defs if none was requested
+func rangeSize(limits subIDLimits, size int) int {
+	if size > 0 {
+		return size
+	}
+	return limits.Count
+}
+
+// addSubordinateRange adds a range to the subordinate ID file at path for
+// the named user, with `usermod -<flag>` if it is available (it is not on
+// busybox based systems) and otherwise by editing the file directly
+func addSubordinateRange(path, flag, name string, startID, length int) error {
+	r := SubIDRange{Owner: name, Start: startID, Length: length}
+	if _, err := resolveBinary(userMod); err == nil {
+		out, err := execCmd(userMod, fmt.Sprintf(cmdTemplates[userMod], flag, startID, startID+length-1, name))
+		if err == nil {
+			return nil
+		}
+		if rerr := AddSubIDRange(path, r); rerr != nil {
+			return fmt.Errorf("usermod failed with output: %s, err: %v; writing %s failed: %v", out, err, path, rerr)
+		}
+		return nil
+	}
+	return AddSubIDRange(path, r)
+}
+
+func findNextUIDRange(limits subIDLimits, length int) (int, error) {
 	ranges, err := parseSubuid("ALL")
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return -1, fmt.Errorf("Couldn't parse all ranges in /etc/subuid file: %v", err)
 	}
 	sort.Sort(ranges)
-	return findNextRangeStart(ranges)
+	return findNextRangeStart(ranges, limits, length)
 }
 
-func findNextGIDRange() (int, error) {
+func findNextGIDRange(limits subIDLimits, length int) (int, error) {
 	ranges, err := parseSubgid("ALL")
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return -1, fmt.Errorf("Couldn't parse all ranges in /etc/subgid file: %v", err)
 	}
 	sort.Sort(ranges)
-	return findNextRangeStart(ranges)
+	return findNextRangeStart(ranges, limits, length)
 }
 
-func findNextRangeStart(rangeList ranges) (int, error) {
-	startID := defaultRangeStart
+// findNextRangeStart returns the start of the first gap of at least length
+// IDs between the limits which isn't used by any of the sorted ranges
+func findNextRangeStart(rangeList ranges, limits subIDLimits, length int) (int, error) {
+	if length <= 0 {
+		return -1, fmt.Errorf("Invalid subordinate range length %d", length)
+	}
+	startID := limits.Min
 	for _, arange := range rangeList {
-		if wouldOverlap(arange, startID) {
+		if wouldOverlap(arange, startID, length) {
 			startID = arange.Start + arange.Length
 		}
 	}
+	if startID+length-1 > limits.Max {
+		return -1, fmt.Errorf("No free range of %d IDs left between %d and %d", length, limits.Min, limits.Max)
+	}
 	return startID, nil
 }
 
-func wouldOverlap(arange subIDRange, ID int) bool {
-	low := ID
-	high := ID + defaultRangeLen
-	if (low >= arange.Start && low <= arange.Start+arange.Length) ||
-		(high <= arange.Start+arange.Length && high >= arange.Start) {
-		return true
-	}
-	return false
+// wouldOverlap reports whether the range of length IDs starting at ID shares
+// any ID with arange, including when either range contains the other
+func wouldOverlap(arange subIDRange, ID, length int) bool {
+	return ID < arange.Start+arange.Length && arange.Start < ID+length
 }
@@ -0,0 +1,87 @@
+package idtools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindNextRangeStart(t *testing.T) {
+	limits := subIDLimits{Min: 100000, Max: 299999, Count: 65536}
+	for _, tc := range []struct {
+		name   string
+		ranges ranges
+		length int
+		start  int
+	}{
+		{"empty", nil, 65536, 100000},
+		{"after one", ranges{{100000, 65536}}, 65536, 165536},
+		{"first gap", ranges{{100000, 1000}, {200000, 1000}}, 65536, 101000},
+		{"gap too small", ranges{{100000, 1000}, {150000, 1000}}, 65536, 151000},
+		{"exact gap", ranges{{100000, 1000}, {101010, 1000}}, 10, 101000},
+		{"below the minimum", ranges{{0, 100500}}, 1000, 100500},
+		{"containing", ranges{{50000, 200000}}, 1000, 250000},
+		{"up to the maximum", ranges{{100000, 190000}}, 10000, 290000},
+		{"exhausted", ranges{{100000, 190000}}, 10001, -1},
+		{"full", ranges{{100000, 200000}}, 1, -1},
+		{"invalid length", nil, 0, -1},
+	} {
+		start, err := findNextRangeStart(tc.ranges, limits, tc.length)
+		if (tc.start < 0) != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if start != tc.start {
+			t.Errorf("%s: got start %d, expected %d", tc.name, start, tc.start)
+		}
+	}
+}
+
+// fakeBusybox puts adduser, addgroup, deluser and delgroup applets linked to
+// a fake busybox, which logs how it was run, first in $PATH
+func fakeBusybox(t *testing.T, dir string) string {
+	log := filepath.Join(dir, "log")
+	script := "#!/bin/sh\necho \"$(basename \"$0\") $*\" >> " + log + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "busybox"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, applet := range []string{"adduser", "addgroup", "deluser", "delgroup"} {
+		if err := os.Symlink("busybox", filepath.Join(dir, applet)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return log
+}
+
+func TestBusyboxUser(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	log := fakeBusybox(t, dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+":"+os.Getenv("PATH"))
+
+	if _, err := resolveBinary("adduser"); err == nil {
+		t.Error("expected resolveBinary to reject a busybox applet")
+	}
+	if path, err := resolveBusyboxApplet("adduser"); err != nil || path != filepath.Join(dir, "adduser") {
+		t.Errorf("resolveBusyboxApplet(adduser) = %q, %v", path, err)
+	}
+	if _, err := resolveBusyboxApplet("busybox"); err != nil {
+		t.Error(err)
+	}
+	if _, err := resolveBusyboxApplet("sh"); err == nil {
+		t.Error("expected sh not to be a busybox applet")
+	}
+
+	if err := addBusyboxUser("remap"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "addgroup -S remap\nadduser -S -D -H -s /bin/false -G remap remap\n"; string(data) != expected {
+		t.Errorf("ran %q, expected %q", data, expected)
+	}
+}
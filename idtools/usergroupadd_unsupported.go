@@ -10,3 +10,23 @@ import "fmt"
 func AddNamespaceRangesUser(name string) (int, int, error) {
 	return -1, -1, fmt.Errorf("No support for adding users or groups on this OS")
 }
+
+// AddSubordinateRanges is not supported on this OS
+func AddSubordinateRanges(name string, size int) error {
+	return fmt.Errorf("No support for adding subordinate ID ranges on this OS")
+}
+
+// AddNamespaceRangesUserWithSize is not supported on this OS
+func AddNamespaceRangesUserWithSize(name string, size int) (int, int, error) {
+	return -1, -1, fmt.Errorf("No support for adding users or groups on this OS")
+}
+
+// DelNamespaceRangesUser is not supported on this OS
+func DelNamespaceRangesUser(name string) error {
+	return fmt.Errorf("No support for deleting users or groups on this OS")
+}
+
+// RemoveSubordinateRanges is not supported on this OS
+func RemoveSubordinateRanges(name string) (int, error) {
+	return 0, fmt.Errorf("No support for removing subordinate ID ranges on this OS")
+}
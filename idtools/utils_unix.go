@@ -26,6 +26,24 @@ func resolveBinary(binname string) (string, error) {
 	return "", fmt.Errorf("Binary %q does not resolve to a binary of that name in $PATH (%q)", binname, resolvedPath)
 }
 
+// resolveBusyboxApplet returns the path of a command in $PATH which is a
+// busybox applet, such as adduser on alpine, that resolveBinary rejects as it
+// resolves to busybox itself
+func resolveBusyboxApplet(applet string) (string, error) {
+	binaryPath, err := exec.LookPath(applet)
+	if err != nil {
+		return "", err
+	}
+	resolvedPath, err := filepath.EvalSymlinks(binaryPath)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Base(resolvedPath) == "busybox" {
+		return binaryPath, nil
+	}
+	return "", fmt.Errorf("Binary %q is not a busybox applet (%q)", applet, resolvedPath)
+}
+
 func execCmd(cmd, args string) ([]byte, error) {
 	execCmd := exec.Command(cmd, strings.Split(args, " ")...)
 	return execCmd.CombinedOutput()
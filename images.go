@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/pkg/progress"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Action: func(clicontext *cli.Context) error {
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		images, err := c.client.ListImages(c.ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "REF\tDIGEST\tSIZE")
+		for _, image := range images {
+			size, err := image.Size(c.ctx)
+			if err != nil {
+				return errors.Wrapf(err, "error getting size of image %s", image.Name())
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", image.Name(), image.Target().Digest, progress.Bytes(size))
+		}
+		return w.Flush()
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:      "pull",
+	Usage:     "pull and unpack an image",
+	ArgsUsage: "IMAGE",
+	Action: func(clicontext *cli.Context) error {
+		ref := clicontext.Args().First()
+		if ref == "" {
+			return errors.New("image reference must be provided")
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		image, err := c.client.Pull(c.ctx, ref, containerd.WithPullUnpack)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't pull image %s", ref)
+		}
+		fmt.Printf("%s: %s\n", image.Name(), image.Target().Digest)
+		return nil
+	},
+}
+
+var versionCommand = cli.Command{
+	Name:  "version",
+	Usage: "print the containerd daemon version",
+	Action: func(clicontext *cli.Context) error {
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		c.printVersion()
+		return nil
+	},
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/typeurl"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// containerInspect is the state of a container as printed by inspect. The
+// spec and runtime options of the container record are decoded into their own
+// fields.
+type containerInspect struct {
+	Container      containers.Container `json:"container"`
+	Spec           *oci.Spec            `json:"spec"`
+	RuntimeOptions interface{}          `json:"runtime_options,omitempty"`
+	Snapshot       *snapshotInspect     `json:"snapshot,omitempty"`
+	IDMappings     *idMappingsInspect   `json:"id_mappings,omitempty"`
+	Task           *taskInspect         `json:"task,omitempty"`
+}
+
+type snapshotInspect struct {
+	Snapshotter string          `json:"snapshotter"`
+	Key         string          `json:"key"`
+	Parent      string          `json:"parent,omitempty"`
+	Kind        snapshots.Kind  `json:"kind"`
+	Usage       snapshots.Usage `json:"usage"`
+}
+
+type idMappingsInspect struct {
+	UIDs []idtools.IDMap `json:"uids"`
+	GIDs []idtools.IDMap `json:"gids"`
+}
+
+type taskInspect struct {
+	ID         string                   `json:"id"`
+	PID        uint32                   `json:"pid"`
+	Status     containerd.ProcessStatus `json:"status"`
+	ExitStatus uint32                   `json:"exit_status,omitempty"`
+	ExitTime   *time.Time               `json:"exit_time,omitempty"`
+}
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "print the container record, spec, snapshot and task state of containers as JSON",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("at least one container name must be provided")
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		var (
+			inspected = []*containerInspect{}
+			exitErr   error
+		)
+		for _, name := range clicontext.Args() {
+			ci, err := c.inspectContainer(name)
+			if err != nil {
+				log.Errorf("failed to inspect container %s: %v", name, err)
+				exitErr = errors.New("failed to inspect one or more containers")
+				continue
+			}
+			inspected = append(inspected, ci)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(inspected); err != nil {
+			return err
+		}
+		return exitErr
+	},
+}
+
+// inspectContainer collects the state of a container from the container
+// store, its snapshotter and its task, if it has one
+func (c *cc) inspectContainer(name string) (*containerInspect, error) {
+	container, err := c.client.LoadContainer(c.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := container.Info(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ci := &containerInspect{}
+
+	if info.Spec != nil {
+		v, err := typeurl.UnmarshalAny(info.Spec)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding spec")
+		}
+		spec, ok := v.(*oci.Spec)
+		if !ok {
+			return nil, errors.Errorf("unexpected spec type %T", v)
+		}
+		ci.Spec = spec
+		info.Spec = nil
+	}
+	if info.Runtime.Options != nil {
+		if ci.RuntimeOptions, err = typeurl.UnmarshalAny(info.Runtime.Options); err != nil {
+			return nil, errors.Wrap(err, "error decoding runtime options")
+		}
+		info.Runtime.Options = nil
+	}
+	ci.Container = info
+
+	if ci.Spec != nil && ci.Spec.Linux != nil && len(ci.Spec.Linux.UIDMappings) > 0 {
+		ci.IDMappings = &idMappingsInspect{
+			UIDs: convertFromOCI(ci.Spec.Linux.UIDMappings),
+			GIDs: convertFromOCI(ci.Spec.Linux.GIDMappings),
+		}
+	}
+
+	if snapshotterName, key := containerSnapshot(info); key != "" {
+		snapshotter := c.client.SnapshotService(snapshotterName)
+		sinfo, err := snapshotter.Stat(c.ctx, key)
+		switch {
+		case err == nil:
+			usage, err := snapshotter.Usage(c.ctx, key)
+			if err != nil {
+				return nil, errors.Wrap(err, "error reading snapshot usage")
+			}
+			ci.Snapshot = &snapshotInspect{
+				Snapshotter: snapshotterName,
+				Key:         key,
+				Parent:      sinfo.Parent,
+				Kind:        sinfo.Kind,
+				Usage:       usage,
+			}
+		case !errdefs.IsNotFound(err):
+			return nil, errors.Wrap(err, "error reading snapshot")
+		}
+	}
+
+	task, err := container.Task(c.ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return ci, nil
+		}
+		return nil, err
+	}
+	status, err := task.Status(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ci.Task = &taskInspect{
+		ID:     task.ID(),
+		PID:    task.Pid(),
+		Status: status.Status,
+	}
+	if status.Status == containerd.Stopped {
+		ci.Task.ExitStatus = status.ExitStatus
+		ci.Task.ExitTime = &status.ExitTime
+	}
+	return ci, nil
+}
+
+// containerSnapshot returns the snapshotter and key of a container's
+// snapshot: its snapshot key or, for an ID-mapped rootfs, which has none, the
+// snapshot its labels refer to
+func containerSnapshot(info containers.Container) (string, string) {
+	if info.SnapshotKey != "" {
+		return info.Snapshotter, info.SnapshotKey
+	}
+	for label, key := range info.Labels {
+		if strings.HasPrefix(label, gcRefSnapshotLabel) {
+			return strings.TrimPrefix(label, gcRefSnapshotLabel), key
+		}
+	}
+	return "", ""
+}
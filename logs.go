@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultLogDir = "/var/log/examplectr"
+
+	// container labels recording where a detached task's output is logged
+	logPathLabel   = "examplectr.log.path"
+	logFormatLabel = "examplectr.log.format"
+
+	// logs written by our log driver are JSON lines with timestamps; logs
+	// written by containerd to a file:// URI are the raw output
+	logFormatJSON = "json"
+	logFormatRaw  = "raw"
+
+	logPollInterval = 250 * time.Millisecond
+
+	// the hidden subcommand the shim runs as the logging binary
+	logDriverName = "log-driver"
+)
+
+// logEntry is a single line of output in a JSON formatted log file
+type logEntry struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Log    string    `json:"log"`
+}
+
+var logsCommand = cli.Command{
+	Name:      "logs",
+	Usage:     "print the output of a detached container",
+	ArgsUsage: "CONTAINER",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow,f",
+			Usage: "keep printing new output until the container's task exits",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Usage: "number of lines to show from the end of the log, or \"all\"",
+			Value: "all",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps",
+			Usage: "prefix each line with the time it was logged",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		name := clicontext.Args().First()
+		if name == "" {
+			return errors.New("container name must be provided")
+		}
+		tail := -1
+		if t := clicontext.String("tail"); t != "all" {
+			n, err := strconv.Atoi(t)
+			if err != nil || n < 0 {
+				return errors.Errorf("invalid --tail value %q", t)
+			}
+			tail = n
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		container, err := c.client.LoadContainer(c.ctx, name)
+		if err != nil {
+			return err
+		}
+		labels, err := container.Labels(c.ctx)
+		if err != nil {
+			return err
+		}
+		path, format := labels[logPathLabel], labels[logFormatLabel]
+		if path == "" {
+			return errors.Errorf("container %s has no log file; only detached containers are logged", name)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "error opening log file")
+		}
+		defer f.Close()
+
+		lr := &logReader{
+			r:          bufio.NewReader(f),
+			format:     format,
+			timestamps: clicontext.Bool("timestamps"),
+		}
+		if err := lr.printTail(tail); err != nil {
+			return err
+		}
+		if !clicontext.Bool("follow") {
+			return nil
+		}
+		for {
+			running, err := taskRunning(c.ctx, container)
+			if err != nil {
+				return err
+			}
+			if err := lr.printAll(); err != nil {
+				return err
+			}
+			if !running {
+				return nil
+			}
+			time.Sleep(logPollInterval)
+		}
+	},
+}
+
+// logReader prints the entries of a log file, holding back a partially
+// written last line until the rest of it is available
+type logReader struct {
+	r          *bufio.Reader
+	format     string
+	timestamps bool
+	partial    []byte
+}
+
+// next returns the next complete entry in the log, or io.EOF if there is none
+func (lr *logReader) next() (logEntry, error) {
+	line, err := lr.r.ReadBytes('\n')
+	lr.partial = append(lr.partial, line...)
+	if err != nil {
+		return logEntry{}, err
+	}
+	line, lr.partial = lr.partial, nil
+	if lr.format != logFormatJSON {
+		return logEntry{Stream: "stdout", Log: string(line)}, nil
+	}
+	var entry logEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return logEntry{}, errors.Wrap(err, "error parsing log file")
+	}
+	return entry, nil
+}
+
+// printTail prints the last n entries of the log, or all of them if n < 0
+func (lr *logReader) printTail(n int) error {
+	if n < 0 {
+		return lr.printAll()
+	}
+	var entries []logEntry
+	for {
+		entry, err := lr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		if len(entries) > n {
+			entries = entries[1:]
+		}
+	}
+	for _, entry := range entries {
+		lr.print(entry)
+	}
+	return nil
+}
+
+// printAll prints every complete entry up to the current end of the log
+func (lr *logReader) printAll() error {
+	for {
+		entry, err := lr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lr.print(entry)
+	}
+}
+
+func (lr *logReader) print(entry logEntry) {
+	out := os.Stdout
+	if entry.Stream == "stderr" {
+		out = os.Stderr
+	}
+	if lr.timestamps && !entry.Time.IsZero() {
+		fmt.Fprintf(out, "%s %s", entry.Time.Format(time.RFC3339Nano), entry.Log)
+		return
+	}
+	fmt.Fprint(out, entry.Log)
+}
+
+// taskRunning reports whether the container has a task which hasn't exited
+func taskRunning(ctx context.Context, container containerd.Container) (bool, error) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	return status.Status != containerd.Stopped, nil
+}
+
+// logIO returns the IO creator for a detached task, which writes the task's
+// output to a log file, and records the log file in the container's labels.
+// Unless a log URI is given, the output is written by examplectr itself
+// running as the shim's logging binary.
+func (c *cc) logIO(container containerd.Container) (cio.Creator, error) {
+	var (
+		creator cio.Creator
+		labels  = map[string]string{}
+	)
+	if c.logURI != "" {
+		u, err := url.Parse(c.logURI)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid log URI %s", c.logURI)
+		}
+		creator = cio.LogURI(u)
+		if u.Scheme == "file" {
+			labels[logPathLabel] = u.Path
+			labels[logFormatLabel] = logFormatRaw
+		}
+	} else {
+		if len(logDriverCommands) == 0 {
+			return nil, errors.New("detached tasks need a log URI on this OS")
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return nil, errors.Wrap(err, "error finding examplectr binary for logging")
+		}
+		ns, _ := namespaces.Namespace(c.ctx)
+		path := filepath.Join(c.logDir, ns, container.ID()+".log")
+		creator = cio.BinaryIO(self, map[string]string{logDriverName: path})
+		labels[logPathLabel] = path
+		labels[logFormatLabel] = logFormatJSON
+	}
+	if len(labels) > 0 {
+		if _, err := container.SetLabels(c.ctx, labels); err != nil {
+			return nil, errors.Wrap(err, "error recording log file in container labels")
+		}
+	}
+	return creator, nil
+}
+
+// removeLogs removes the log file written by our log driver for a container
+func removeLogs(labels map[string]string) error {
+	path := labels[logPathLabel]
+	if path == "" || labels[logFormatLabel] != logFormatJSON {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
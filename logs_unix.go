@@ -0,0 +1,83 @@
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/runtime/v2/logging"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// logDriverCommands are the platform's hidden commands writing the output of
+// detached tasks
+var logDriverCommands = []cli.Command{logDriverCommand}
+
+// logDriverCommand is run by the containerd shim as the logging binary of
+// detached tasks; it is not meant to be run directly
+var logDriverCommand = cli.Command{
+	Name:      logDriverName,
+	Usage:     "write a task's output to a JSON log file (run by the containerd shim)",
+	ArgsUsage: "PATH",
+	Hidden:    true,
+	Action: func(clicontext *cli.Context) error {
+		path := clicontext.Args().First()
+		if path == "" {
+			return errors.New("log file path must be provided")
+		}
+		logging.Run(func(ctx context.Context, config *logging.Config, ready func() error) error {
+			return writeLogs(path, config, ready)
+		})
+		return nil
+	},
+}
+
+// writeLogs copies the task's stdout and stderr to the log file as JSON
+// entries until both streams are closed
+func writeLogs(path string, config *logging.Config, ready func() error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		enc = json.NewEncoder(f)
+	)
+	copyStream := func(stream string, r io.Reader) {
+		defer wg.Done()
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				mu.Lock()
+				enc.Encode(logEntry{Time: time.Now().UTC(), Stream: stream, Log: line})
+				mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go copyStream("stdout", config.Stdout)
+	go copyStream("stderr", config.Stderr)
+	if err := ready(); err != nil {
+		return err
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,9 @@
+// +build windows
+
+package main
+
+import "github.com/urfave/cli"
+
+// logDriverCommands is empty as the shim's logging binary protocol is not
+// supported on Windows; detached tasks need a log URI instead
+var logDriverCommands []cli.Command
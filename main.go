@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultContainerdPath = "/run/containerd/containerd.sock"
+	defaultNamespace      = "examplectr"
+	defaultImage          = "docker.io/library/alpine:latest"
+	defaultConnectTimeout = 10 * time.Second
+	defaultStopSignal     = "SIGTERM"
+	defaultStopTimeout    = 10 * time.Second
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "examplectr"
+	app.Usage = "example containerd client"
+	app.Flags = append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "enable debug output in logs",
+		},
+	}, configFlags...)
+	app.Commands = append([]cli.Command{
+		runCommand,
+		createCommand,
+		startCommand,
+		attachCommand,
+		execCommand,
+		stopCommand,
+		rmCommand,
+		psCommand,
+		inspectCommand,
+		imagesCommand,
+		pruneCommand,
+		pullCommand,
+		usernsCommand,
+		logsCommand,
+		versionCommand,
+		doctorCommand,
+		usernsHolderCommand,
+	}, logDriverCommands...)
+	app.Before = func(clicontext *cli.Context) error {
+		if clicontext.GlobalBool("debug") {
+			log.SetLevel(log.DebugLevel)
+		}
+		return nil
+	}
+	if err := app.Run(os.Args); err != nil {
+		log.Error(err)
+		os.Exit(-1)
+	}
+}
+
+// newCC connects to the containerd daemon and returns a client object with
+// a context scoped to the configured namespace. The caller must call close
+// when done with the client.
+func newCC(clicontext *cli.Context) (*cc, error) {
+	cfg, err := loadConfig(clicontext)
+	if err != nil {
+		return nil, err
+	}
+
+	// connect to containerd daemon over UNIX socket
+	client, err := containerd.New(cfg.Address,
+		containerd.WithTimeout(cfg.ConnectTimeout.Duration),
+		containerd.WithDefaultNamespace(cfg.Namespace))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to containerd daemon at %s", cfg.Address)
+	}
+
+	// set up a namespace
+	ctx := namespaces.WithNamespace(context.Background(), cfg.Namespace)
+	cancel := func() {}
+	if cfg.Timeout.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout.Duration)
+	}
+
+	return &cc{
+		ctx:      ctx,
+		cancel:   cancel,
+		client:   client,
+		name:     fmt.Sprintf("exampleCtr-%d", os.Getpid()),
+		logDir:   cfg.LogDir,
+		stateDir: cfg.StateDir,
+	}, nil
+}
+
+// close releases the client's context and daemon connection
+func (c *cc) close() {
+	c.cancel()
+	c.client.Close()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// status shown for containers which have no task
+const statusCreated = "created"
+
+// containerSummary is a container as listed by ps
+type containerSummary struct {
+	ID        string            `json:"id"`
+	Image     string            `json:"image"`
+	Runtime   string            `json:"runtime"`
+	Status    string            `json:"status"`
+	CreatedAt time.Time         `json:"created_at"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	PIDs      []uint32          `json:"pids,omitempty"`
+}
+
+// Field implements filters.Adaptor, so that containers can be matched by
+// their id, image, runtime, status and labels
+func (s *containerSummary) Field(fieldpath []string) (string, bool) {
+	if len(fieldpath) == 0 {
+		return "", false
+	}
+	switch fieldpath[0] {
+	case "id":
+		return s.ID, len(s.ID) > 0
+	case "image":
+		return s.Image, len(s.Image) > 0
+	case "runtime":
+		return s.Runtime, len(s.Runtime) > 0
+	case "status":
+		return s.Status, len(s.Status) > 0
+	case "labels":
+		if len(fieldpath) < 2 {
+			return "", false
+		}
+		value, ok := s.Labels[strings.Join(fieldpath[1:], ".")]
+		return value, ok
+	}
+	return "", false
+}
+
+var psCommand = cli.Command{
+	Name:  "ps",
+	Usage: "list containers with the status of their tasks",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "only list containers matching a containerd filter `EXPR` on id, image, runtime, status or labels, e.g. 'status==running,labels.app==web'; any of several filters may match",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "print the containers as a table, as JSON or with a Go template, e.g. '{{.ID}} {{.Status}}'",
+			Value: "table",
+		},
+		cli.BoolFlag{
+			Name:  "quiet,q",
+			Usage: "only print container IDs",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		filter, err := filters.ParseAll(clicontext.StringSlice("filter")...)
+		if err != nil {
+			return errors.Wrap(err, "invalid filter")
+		}
+		format := clicontext.String("format")
+		var tmpl *template.Template
+		if format != "table" && format != "json" {
+			if tmpl, err = template.New("ps").Parse(format); err != nil {
+				return errors.Wrap(err, "invalid format template")
+			}
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		containers, err := c.client.Containers(c.ctx)
+		if err != nil {
+			return err
+		}
+		summaries := []*containerSummary{}
+		for _, container := range containers {
+			summary, err := c.summarize(container)
+			if err != nil {
+				if errdefs.IsNotFound(err) {
+					// removed while we were listing
+					continue
+				}
+				return errors.Wrapf(err, "error reading container %s", container.ID())
+			}
+			if filter.Match(summary) {
+				summaries = append(summaries, summary)
+			}
+		}
+
+		switch {
+		case clicontext.Bool("quiet"):
+			for _, summary := range summaries {
+				fmt.Println(summary.ID)
+			}
+			return nil
+		case format == "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(summaries)
+		case tmpl != nil:
+			for _, summary := range summaries {
+				if err := tmpl.Execute(os.Stdout, summary); err != nil {
+					return errors.Wrap(err, "error executing format template")
+				}
+				fmt.Println()
+			}
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER\tIMAGE\tSTATUS\tCREATED\tPIDS")
+		for _, summary := range summaries {
+			pids := make([]string, len(summary.PIDs))
+			for i, pid := range summary.PIDs {
+				pids[i] = fmt.Sprint(pid)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", summary.ID, summary.Image, summary.Status,
+				summary.CreatedAt.Local().Format("2006-01-02 15:04:05"), strings.Join(pids, ","))
+		}
+		return w.Flush()
+	},
+}
+
+// summarize returns the summary of a container, including the status and
+// processes of its task if it has one
+func (c *cc) summarize(container containerd.Container) (*containerSummary, error) {
+	info, err := container.Info(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := &containerSummary{
+		ID:        info.ID,
+		Image:     info.Image,
+		Runtime:   info.Runtime.Name,
+		Status:    statusCreated,
+		CreatedAt: info.CreatedAt,
+		Labels:    info.Labels,
+	}
+	task, err := container.Task(c.ctx, nil)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return summary, nil
+		}
+		return nil, err
+	}
+	status, err := task.Status(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary.Status = string(status.Status)
+	if status.Status == containerd.Running || status.Status == containerd.Paused {
+		processes, err := task.Pids(c.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range processes {
+			summary.PIDs = append(summary.PIDs, p.Pid)
+		}
+	}
+	return summary, nil
+}
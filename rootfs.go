@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const (
+	// container label holding the key of the unmodified image snapshot
+	// mounted through an ID-mapped mount as the container's rootfs
+	idmappedRootfsLabel = "examplectr.rootfs.idmapped"
+	// prefix of the container label, followed by the snapshotter, keeping
+	// the snapshot of an ID-mapped rootfs from garbage collection
+	gcRefSnapshotLabel = "containerd.io/gc.ref.snapshot."
+
+	// how a user namespaced container's rootfs is given the remapped owners
+	remapRootfsAuto  = "auto"  // ID-mapped mount where supported, else chown
+	remapRootfsIDMap = "idmap" // ID-mapped mount only
+	remapRootfsChown = "chown" // remapped copy of the image snapshot
+)
+
+// errIDMapUnsupported is returned when the kernel, or the filesystem of the
+// image snapshot, does not support ID-mapped mounts, or when the remapped
+// root cannot reach the mount point of the container's rootfs
+var errIDMapUnsupported = errors.New("ID-mapped mounts are not supported")
+
+// idmappedRootfsPath returns where the ID-mapped rootfs of a container is
+// mounted. The remapped root must be able to traverse the directories
+// leading to it.
+func (c *cc) idmappedRootfsPath(id string) string {
+	ns, _ := namespaces.Namespace(c.ctx)
+	return filepath.Join(c.stateDir, "rootfs", ns, id)
+}
+
+// withIDMappedRootfs records the image and the snapshot mounted as the
+// container's ID-mapped rootfs. The container has no snapshot key, so that
+// containerd doesn't mount the snapshot for the task as well, and instead
+// refers to the snapshot with a label keeping it from garbage collection.
+func withIDMappedRootfs(key string, image containerd.Image) containerd.NewContainerOpts {
+	return func(_ context.Context, _ *containerd.Client, c *containers.Container) error {
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+		c.Labels[idmappedRootfsLabel] = key
+		c.Labels[gcRefSnapshotLabel+containerd.DefaultSnapshotter] = key
+		c.Image = image.Name()
+		return nil
+	}
+}
+
+var usernsHolderCommand = cli.Command{
+	Name:   "userns-holder",
+	Usage:  "hold a user namespace open until stdin is closed (run by examplectr)",
+	Hidden: true,
+	Action: func(clicontext *cli.Context) error {
+		_, err := io.Copy(ioutil.Discard, os.Stdin)
+		return err
+	},
+}
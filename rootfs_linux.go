@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// constants of the new mount API missing from our golang.org/x/sys, which
+// has the syscall numbers of open_tree and move_mount but not mount_setattr
+const (
+	openTreeClone       = 0x1
+	atRecursive         = 0x8000
+	moveMountFEmptyPath = 0x4
+	mountAttrIDMap      = 0x100000
+)
+
+// mountAttr is struct mount_attr of mount_setattr(2)
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// mountIDMappedRootfs prepares an unmodified snapshot of the image for the
+// client's container and mounts it through an ID-mapped mount, which shows
+// the files with the owners the client's ID mappings give them, at the
+// container's rootfs path. It returns errIDMapUnsupported if the kernel or
+// the snapshot's filesystem cannot do this.
+func (c *cc) mountIDMappedRootfs(ctx context.Context, image containerd.Image) (string, error) {
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return "", err
+	}
+	snapshotter := c.client.SnapshotService(containerd.DefaultSnapshotter)
+	mounts, err := snapshotter.Prepare(ctx, c.name, identity.ChainID(diffIDs).String())
+	if err != nil {
+		return "", err
+	}
+	target := c.idmappedRootfsPath(c.name)
+	if err := c.idmapMount(mounts, target); err != nil {
+		os.Remove(target)
+		snapshotter.Remove(ctx, c.name)
+		return "", err
+	}
+	return target, nil
+}
+
+// remountIDMappedRootfs mounts the ID-mapped rootfs of a container again if
+// it is gone, such as after a reboot
+func (c *cc) remountIDMappedRootfs(ctx context.Context, container containerd.Container) error {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return err
+	}
+	key := labels[idmappedRootfsLabel]
+	if key == "" {
+		return nil
+	}
+	target := c.idmappedRootfsPath(container.ID())
+	if info, err := mount.Lookup(target); err == nil && info.Mountpoint == target {
+		return nil
+	}
+	if c.idMappings == nil {
+		return errors.Errorf("container %s has an ID-mapped rootfs but no ID mappings", container.ID())
+	}
+	mounts, err := c.client.SnapshotService(containerd.DefaultSnapshotter).Mounts(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.idmapMount(mounts, target)
+}
+
+// unmountIDMappedRootfs unmounts the ID-mapped rootfs of a removed container
+// and removes its snapshot
+func (c *cc) unmountIDMappedRootfs(ctx context.Context, id, key string) error {
+	target := c.idmappedRootfsPath(id)
+	if err := unix.Unmount(target, unix.MNT_DETACH); err != nil && err != unix.EINVAL && err != unix.ENOENT {
+		return &os.PathError{Op: "unmount", Path: target, Err: err}
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := c.client.SnapshotService(containerd.DefaultSnapshotter).Remove(ctx, key)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// idmapMount mounts mounts at target through an ID-mapped mount using the
+// client's ID mappings: the mounts are made at a staging directory, cloned
+// into a detached mount tree which is given the mappings, and the tree is
+// attached at target
+func (c *cc) idmapMount(mounts []mount.Mount, target string) error {
+	root := c.idMappings.RootPair()
+	if err := os.MkdirAll(filepath.Dir(target), 0711); err != nil {
+		return err
+	}
+	if err := os.Mkdir(target, 0711); err != nil && !os.IsExist(err) {
+		return err
+	}
+	if err := searchableStateDirs(c.stateDir, filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := idtools.CheckParentAccess(target, root); err != nil {
+		return errors.Wrapf(errIDMapUnsupported, "the remapped root cannot reach the container's rootfs: %v", err)
+	}
+
+	staging, err := ioutil.TempDir(filepath.Dir(target), "."+filepath.Base(target)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staging)
+	if err := mount.All(mounts, staging); err != nil {
+		return errors.Wrap(err, "error mounting snapshot")
+	}
+	// the attached clone keeps the filesystem mounted
+	defer mount.UnmountAll(staging, unix.MNT_DETACH)
+
+	usernsFd, err := userNamespaceFd(c.idMappings)
+	if err != nil {
+		return errors.Wrap(err, "error creating user namespace for the ID mappings")
+	}
+	defer usernsFd.Close()
+
+	path, err := unix.BytePtrFromString(staging)
+	if err != nil {
+		return err
+	}
+	atFdcwd := unix.AT_FDCWD
+	treeFd, _, errno := unix.Syscall(unix.SYS_OPEN_TREE, uintptr(atFdcwd), uintptr(unsafe.Pointer(path)),
+		uintptr(openTreeClone|unix.O_CLOEXEC|atRecursive))
+	if errno != 0 {
+		return idmapError("open_tree", errno)
+	}
+	defer unix.Close(int(treeFd))
+
+	attr := mountAttr{attrSet: mountAttrIDMap, usernsFd: uint64(usernsFd.Fd())}
+	empty, err := unix.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall6(sysMountSetattr, treeFd, uintptr(unsafe.Pointer(empty)),
+		uintptr(unix.AT_EMPTY_PATH|atRecursive), uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0); errno != 0 {
+		return idmapError("mount_setattr", errno)
+	}
+
+	dest, err := unix.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall6(unix.SYS_MOVE_MOUNT, treeFd, uintptr(unsafe.Pointer(empty)),
+		uintptr(atFdcwd), uintptr(unsafe.Pointer(dest)), moveMountFEmptyPath, 0); errno != 0 {
+		return &os.PathError{Op: "move_mount", Path: target, Err: errno}
+	}
+	log.Debugf("mounted ID-mapped rootfs at %s", target)
+	return nil
+}
+
+// searchableStateDirs gives everyone the search permission on the
+// directories from the state directory down to dir, which MkdirAll leaves
+// alone if they exist, such as a state directory created only accessible to
+// root before ID-mapped mounts were used
+func searchableStateDirs(stateDir, dir string) error {
+	rel, err := filepath.Rel(stateDir, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return errors.Errorf("%s is not within the state directory %s", dir, stateDir)
+	}
+	dir = stateDir
+	for _, name := range append([]string{"."}, strings.Split(rel, string(filepath.Separator))...) {
+		dir = filepath.Join(dir, name)
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if mode := fi.Mode().Perm(); mode&0111 != 0111 {
+			if err := os.Chmod(dir, mode|0111); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// idmapError reports a failed mount API call, as errIDMapUnsupported if the
+// call or ID-mapping the filesystem is not supported
+func idmapError(call string, errno syscall.Errno) error {
+	switch errno {
+	case unix.ENOSYS, unix.EINVAL, unix.EOPNOTSUPP:
+		return errors.Wrapf(errIDMapUnsupported, "%s: %v", call, errno)
+	}
+	return errors.Wrap(errno, call)
+}
+
+// userNamespaceFd returns an open file of a user namespace with the given ID
+// mappings. The namespace is created for a short-lived child process and
+// stays alive as long as the file, or a mount using it, does.
+func userNamespaceFd(idMappings *idtools.IDMappings) (*os.File, error) {
+	cmd := exec.Command("/proc/self/exe", usernsHolderCommand.Name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: sysProcIDMap(idMappings.UIDs()),
+		GidMappings: sysProcIDMap(idMappings.GIDs()),
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+	return os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+}
+
+func sysProcIDMap(idMap []idtools.IDMap) []syscall.SysProcIDMap {
+	sysMap := make([]syscall.SysProcIDMap, len(idMap))
+	for i, m := range idMap {
+		sysMap[i] = syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size}
+	}
+	return sysMap
+}
@@ -0,0 +1,8 @@
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package main
+
+// sysMountSetattr is the syscall number of mount_setattr(2). Syscalls added
+// since Linux 5.1 have the same number on every architecture except for the
+// offset of the mips ABIs.
+const sysMountSetattr = 442
@@ -0,0 +1,8 @@
+// +build linux
+// +build mips64 mips64le
+
+package main
+
+// sysMountSetattr is the syscall number of mount_setattr(2) in the n64 ABI,
+// whose numbers start at 5000
+const sysMountSetattr = 5442
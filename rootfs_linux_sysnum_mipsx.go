@@ -0,0 +1,8 @@
+// +build linux
+// +build mips mipsle
+
+package main
+
+// sysMountSetattr is the syscall number of mount_setattr(2) in the o32 ABI,
+// whose numbers start at 4000
+const sysMountSetattr = 4442
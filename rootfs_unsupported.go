@@ -0,0 +1,24 @@
+// +build !linux
+
+package main
+
+import (
+	"context"
+
+	"github.com/containerd/containerd"
+)
+
+// mountIDMappedRootfs is not supported on this OS
+func (c *cc) mountIDMappedRootfs(ctx context.Context, image containerd.Image) (string, error) {
+	return "", errIDMapUnsupported
+}
+
+// remountIDMappedRootfs has nothing to remount on this OS
+func (c *cc) remountIDMappedRootfs(ctx context.Context, container containerd.Container) error {
+	return nil
+}
+
+// unmountIDMappedRootfs has nothing to unmount on this OS
+func (c *cc) unmountIDMappedRootfs(ctx context.Context, id, key string) error {
+	return nil
+}
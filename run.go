@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// flag for the commands which can start detached tasks
+var logURIFlag = cli.StringFlag{
+	Name:  "log-uri",
+	Usage: "log a detached task's output to a file:// or binary:// `URI` instead of the log directory",
+}
+
+// flags shared by the commands which create containers
+var containerFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "name",
+		Usage: "name of the container (default: exampleCtr-<pid>)",
+	},
+	cli.StringFlag{
+		Name:  "userns",
+		Usage: "run in a user namespace using the subordinate UIDs of the user and GIDs of the group (`USER[:GROUP]`, the group defaulting to the user)",
+	},
+	cli.BoolFlag{
+		Name:  "userns-auto",
+		Usage: "give the container its own slice of the --userns ID ranges, freed when it is removed",
+	},
+	cli.IntFlag{
+		Name:  "userns-size",
+		Usage: "number of IDs in a slice allocated with --userns-auto",
+		Value: idtools.DefaultSliceSize,
+	},
+	cli.StringSliceFlag{
+		Name:  "uidmap",
+		Usage: "run in a user namespace mapping container UIDs to host UIDs (`CONTAINER:HOST:SIZE`)",
+	},
+	cli.StringSliceFlag{
+		Name:  "gidmap",
+		Usage: "map container GIDs to host GIDs (`CONTAINER:HOST:SIZE`); defaults to the UID mappings",
+	},
+	cli.StringFlag{
+		Name:  "idmap-file",
+		Usage: "read the UID and GID mappings from a JSON or YAML `FILE` of uids and gids lists",
+	},
+	cli.StringFlag{
+		Name:  "remap-rootfs",
+		Usage: "how a user namespaced container's rootfs gets the remapped owners: idmap (an ID-mapped mount), chown (a remapped copy of the image) or auto",
+		Value: remapRootfsAuto,
+	},
+	cli.BoolFlag{
+		Name:  "tty,t",
+		Usage: "allocate a TTY for the container process",
+	},
+	cli.BoolFlag{
+		Name:  "interactive,i",
+		Usage: "attach our stdin to the container",
+	},
+	cli.StringFlag{
+		Name:  "entrypoint",
+		Usage: "override the image entrypoint; the image command is not used",
+	},
+	cli.StringFlag{
+		Name:  "workdir,w",
+		Usage: "working directory of the container process",
+	},
+	cli.StringSliceFlag{
+		Name:  "env,e",
+		Usage: "set an environment variable (`KEY=VALUE`), or unset it when no value is given",
+	},
+	cli.StringSliceFlag{
+		Name:  "env-file",
+		Usage: "read environment variables from a file of KEY=VALUE lines",
+	},
+	cli.StringFlag{
+		Name:  "user,u",
+		Usage: "user to run the process as (`USER[:GROUP]` or UID[:GID])",
+	},
+}
+
+var runCommand = cli.Command{
+	Name:      "run",
+	Usage:     "run a container, waiting for it to exit if a command is given",
+	ArgsUsage: "[IMAGE [-- COMMAND [ARG...]]]",
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "rm",
+			Usage: "wait for the container to exit, then remove it and its snapshot",
+		},
+		cli.BoolFlag{
+			Name:  "detach,d",
+			Usage: "run the container in the background, logging its output",
+		},
+		logURIFlag,
+		detachKeysFlag,
+	}, containerFlags...),
+	// everything after the image is the container's argv
+	SkipArgReorder: true,
+	Action: func(clicontext *cli.Context) error {
+		detachKeys, err := parseDetachKeys(clicontext.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		if err := c.setContainerOpts(clicontext); err != nil {
+			return err
+		}
+		c.remove = clicontext.Bool("rm")
+		c.detach = clicontext.Bool("detach")
+		c.logURI = clicontext.String("log-uri")
+		c.detachKeys = detachKeys
+		if c.detach && (c.remove || c.tty || c.stdin) {
+			return errors.New("--detach cannot be used with --rm, --tty or --interactive")
+		}
+		exitStatus, err := c.runContainer()
+		if err == errDetached {
+			log.Infof("detached from container %s", c.name)
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to run container")
+		}
+		if exitStatus.Error() != nil {
+			log.Errorf("container exited with error: %v", exitStatus.Error())
+		}
+		if code := exitStatus.ExitCode(); code != 0 {
+			return cli.NewExitError("", int(code))
+		}
+		return nil
+	},
+}
+
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "create a container without starting it",
+	ArgsUsage: "[IMAGE [-- COMMAND [ARG...]]]",
+	Flags:     containerFlags,
+	// everything after the image is the container's argv
+	SkipArgReorder: true,
+	Action: func(clicontext *cli.Context) error {
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		if err := c.setContainerOpts(clicontext); err != nil {
+			return err
+		}
+		image, err := c.getImage()
+		if err != nil {
+			return err
+		}
+		container, err := c.newContainer(image)
+		if err != nil {
+			return errors.Wrap(err, "error creating container")
+		}
+		fmt.Println(container.ID())
+		return nil
+	},
+}
+
+// setContainerOpts fills in the container configuration of the client from
+// the command line
+func (c *cc) setContainerOpts(clicontext *cli.Context) error {
+	if name := clicontext.String("name"); name != "" {
+		c.name = name
+	}
+	c.image = defaultImage
+	if clicontext.NArg() > 0 {
+		c.image = clicontext.Args().First()
+	}
+	c.args = clicontext.Args().Tail()
+	if len(c.args) > 0 && c.args[0] == "--" {
+		c.args = c.args[1:]
+	}
+	c.tty = clicontext.Bool("tty")
+	c.stdin = clicontext.Bool("interactive")
+	switch c.remapRootfs = clicontext.String("remap-rootfs"); c.remapRootfs {
+	case remapRootfsAuto, remapRootfsIDMap, remapRootfsChown:
+	default:
+		return errors.Errorf("invalid --remap-rootfs %q: must be auto, idmap or chown", c.remapRootfs)
+	}
+	if err := c.setProcessOpts(clicontext); err != nil {
+		return err
+	}
+	return c.setIDMappings(clicontext)
+}
+
+// setIDMappings sets the ID mappings of the user namespace to run in, if any,
+// from the subordinate ID ranges of a user and group, from explicit mappings
+// or from a mapping file
+func (c *cc) setIDMappings(clicontext *cli.Context) error {
+	var (
+		userns    = clicontext.String("userns")
+		uidmaps   = clicontext.StringSlice("uidmap")
+		gidmaps   = clicontext.StringSlice("gidmap")
+		idmapFile = clicontext.String("idmap-file")
+		sources   int
+	)
+	for _, set := range []bool{userns != "", len(uidmaps) > 0 || len(gidmaps) > 0, idmapFile != ""} {
+		if set {
+			sources++
+		}
+	}
+	if clicontext.Bool("userns-auto") && userns == "" {
+		return errors.New("--userns-auto requires --userns")
+	}
+	switch {
+	case sources > 1:
+		return errors.New("only one of --userns, --uidmap/--gidmap and --idmap-file can be used")
+	case sources == 0:
+		log.Warnf("Not running with user namespaces")
+		return nil
+	case userns != "":
+		username, groupname, err := parseUserGroup(userns)
+		if err != nil {
+			return err
+		}
+		if clicontext.Bool("userns-auto") {
+			c.idAllocator = &idtools.RangeAllocator{
+				StatePath: c.usernsStatePath(),
+				User:      username,
+				Group:     groupname,
+				Size:      clicontext.Int("userns-size"),
+				Stale:     c.allocationStale,
+			}
+			return nil
+		}
+		idMappings, err := idtools.NewIDMappings(username, groupname)
+		if err != nil {
+			return errors.Wrapf(err, "error finding ID mappings for %s", userns)
+		}
+		c.idMappings = idMappings
+		c.usernsUser = username + ":" + groupname
+		return nil
+	case idmapFile != "":
+		uids, gids, err := idtools.LoadIDMapFile(idmapFile)
+		if err != nil {
+			return err
+		}
+		c.idMappings = idtools.NewIDMappingsFromMaps(uids, gids)
+		return nil
+	}
+	if len(uidmaps) == 0 {
+		return errors.New("--gidmap requires --uidmap")
+	}
+	uids, err := parseIDMaps(uidmaps)
+	if err != nil {
+		return err
+	}
+	gids := uids
+	if len(gidmaps) > 0 {
+		if gids, err = parseIDMaps(gidmaps); err != nil {
+			return err
+		}
+	}
+	c.idMappings = idtools.NewIDMappingsFromMaps(uids, gids)
+	return nil
+}
+
+// parseUserGroup splits a USER[:GROUP] value; the group defaults to the user
+func parseUserGroup(s string) (string, string, error) {
+	username, groupname := s, s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		username, groupname = s[:i], s[i+1:]
+	}
+	if username == "" || groupname == "" {
+		return "", "", errors.Errorf("invalid user %q; expected USER[:GROUP]", s)
+	}
+	return username, groupname, nil
+}
+
+// parseIDMaps parses ID mappings given as CONTAINER:HOST:SIZE
+func parseIDMaps(specs []string) ([]idtools.IDMap, error) {
+	idMaps := make([]idtools.IDMap, len(specs))
+	for i, spec := range specs {
+		m, err := idtools.ParseIDMap(spec)
+		if err != nil {
+			return nil, err
+		}
+		idMaps[i] = m
+	}
+	return idMaps, nil
+}
+
+// setProcessOpts adds the spec options which override the process
+// configuration taken from the image
+func (c *cc) setProcessOpts(clicontext *cli.Context) error {
+	if entrypoint := clicontext.String("entrypoint"); entrypoint != "" {
+		c.args = append([]string{entrypoint}, c.args...)
+	}
+	if cwd := clicontext.String("workdir"); cwd != "" {
+		c.specOpts = append(c.specOpts, oci.WithProcessCwd(cwd))
+	}
+	var env []string
+	for _, path := range clicontext.StringSlice("env-file") {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "error reading env file %s", path)
+		}
+		env = append(env, vars...)
+	}
+	env = append(env, clicontext.StringSlice("env")...)
+	if len(env) > 0 {
+		c.specOpts = append(c.specOpts, oci.WithEnv(env))
+	}
+	if user := clicontext.String("user"); user != "" {
+		c.specOpts = append(c.specOpts, oci.WithUser(user))
+	}
+	return nil
+}
+
+// readEnvFile returns the KEY=VALUE lines of an env file, skipping blank
+// lines and comments
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		vars = append(vars, line)
+	}
+	return vars, s.Err()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/containerd/containerd"
+	log "github.com/sirupsen/logrus"
+)
+
+// killer is implemented by tasks and processes which can be sent signals
+type killer interface {
+	Kill(context.Context, syscall.Signal, ...containerd.KillOpts) error
+}
+
+// catchSignals starts queueing every catchable signal sent to us, so that
+// none of them terminate the client before they can be forwarded to a task
+func catchSignals() chan os.Signal {
+	sigc := make(chan os.Signal, 128)
+	signal.Notify(sigc)
+	return sigc
+}
+
+// forwardSignals forwards the signals queued on sigc to the task until sigc
+// is closed. A second interrupt escalates to SIGKILL so that a task ignoring
+// SIGINT can still be stopped from the keyboard.
+func forwardSignals(ctx context.Context, task killer, sigc chan os.Signal) {
+	go func() {
+		interrupted := false
+		for s := range sigc {
+			sig, ok := s.(syscall.Signal)
+			if !ok || ignoredSignals[sig] {
+				continue
+			}
+			if sig == syscall.SIGINT {
+				if interrupted {
+					log.Warnf("received second interrupt; killing task")
+					sig = syscall.SIGKILL
+				}
+				interrupted = true
+			}
+			log.Debugf("forwarding signal %s", sig)
+			if err := task.Kill(ctx, sig); err != nil {
+				log.Errorf("error forwarding signal %s: %v", sig, err)
+			}
+		}
+	}()
+}
+
+// stopCatch stops catching signals and ends forwarding on sigc
+func stopCatch(sigc chan os.Signal) {
+	signal.Stop(sigc)
+	close(sigc)
+}
@@ -0,0 +1,14 @@
+// +build !windows
+
+package main
+
+import "syscall"
+
+// signals which are about the client itself and are never forwarded; SIGURG
+// is used internally by the Go runtime for goroutine preemption
+var ignoredSignals = map[syscall.Signal]bool{
+	syscall.SIGCHLD:  true,
+	syscall.SIGPIPE:  true,
+	syscall.SIGURG:   true,
+	syscall.SIGWINCH: true,
+}
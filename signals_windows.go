@@ -0,0 +1,10 @@
+// +build windows
+
+package main
+
+import "syscall"
+
+// signals which are about the client itself and are never forwarded
+var ignoredSignals = map[syscall.Signal]bool{
+	syscall.SIGPIPE: true,
+}
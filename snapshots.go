@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/opencontainers/image-spec/identity"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	// labels of a remapped base snapshot, identifying the image layers and
+	// ID mappings it was made from
+	remapChainIDLabel = "examplectr.remap.chainid"
+	remapUIDLabel     = "examplectr.remap.uid"
+	remapGIDLabel     = "examplectr.remap.gid"
+	remapUIDMapLabel  = "examplectr.remap.uidmap"
+	remapGIDMapLabel  = "examplectr.remap.gidmap"
+	// the --userns USER:GROUP whose subordinate ranges the mappings are
+	remapUsernsLabel = "examplectr.remap.userns"
+
+	// container label naming the remapped base snapshot the container's
+	// snapshot was prepared from
+	remapBaseLabel = "examplectr.remap.base"
+
+	// keeps the base snapshots from containerd's garbage collection while no
+	// container uses them; pruneRemappedSnapshots removes them instead
+	gcRootLabel = "containerd.io/gc.root"
+
+	// how long a remapped base snapshot is kept from pruning after it was
+	// created or last chosen for a container, which covers the time until
+	// the container's snapshot is prepared on top of it
+	remapBaseGracePeriod = 10 * time.Minute
+)
+
+var pruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "remove remapped base snapshots whose image or ID mappings are gone",
+	Action: func(clicontext *cli.Context) error {
+		c, err := newCC(clicontext)
+		if err != nil {
+			return err
+		}
+		defer c.close()
+
+		removed, err := c.pruneRemappedSnapshots(c.ctx)
+		for _, key := range removed {
+			fmt.Println(key)
+		}
+		return err
+	},
+}
+
+// remappedBaseKey returns the key of the base snapshot of an image's layers
+// remapped for a set of ID mappings. The whole mappings, rather than just the
+// remapped root, are part of the key since every file owner is remapped.
+func remappedBaseKey(chainID string, idMappings *idtools.IDMappings) string {
+	sum := sha256.Sum256([]byte(idtools.FormatIDMap(idMappings.UIDs()) + "/" +
+		idtools.FormatIDMap(idMappings.GIDs())))
+	return fmt.Sprintf("examplectr-remap-%s-%x", chainID, sum[:8])
+}
+
+// withRemappedBaseSnapshot gives the container an active snapshot on top of
+// the base snapshot of the image remapped for the client's ID mappings. The
+// base snapshot is created and committed the first time the image is used
+// with the mappings, so later containers don't pay for remapping the image.
+func (c *cc) withRemappedBaseSnapshot(id string, image containerd.Image) containerd.NewContainerOpts {
+	return func(ctx context.Context, client *containerd.Client, container *containers.Container) error {
+		diffIDs, err := image.RootFS(ctx)
+		if err != nil {
+			return err
+		}
+		chainID := identity.ChainID(diffIDs).String()
+		if container.Snapshotter == "" {
+			container.Snapshotter = containerd.DefaultSnapshotter
+		}
+		snapshotter := client.SnapshotService(container.Snapshotter)
+
+		base := remappedBaseKey(chainID, c.idMappings)
+		for retried := false; ; retried = true {
+			if err := c.useRemappedBase(ctx, snapshotter, base, chainID); err != nil {
+				return errors.Wrapf(err, "error creating remapped snapshot of %s", image.Name())
+			}
+			_, err := snapshotter.Prepare(ctx, id, base)
+			if err == nil {
+				break
+			}
+			// the base was pruned by another client since it was found,
+			// so it is created again
+			if !errdefs.IsNotFound(err) || retried {
+				return err
+			}
+		}
+		container.SnapshotKey = id
+		container.Image = image.Name()
+		if container.Labels == nil {
+			container.Labels = map[string]string{}
+		}
+		container.Labels[remapBaseLabel] = base
+		return nil
+	}
+}
+
+// useRemappedBase creates the base snapshot if it does not exist, and
+// otherwise marks it as used now so that it is not pruned before the
+// container's snapshot is prepared on top of it
+func (c *cc) useRemappedBase(ctx context.Context, snapshotter snapshots.Snapshotter, base, chainID string) error {
+	info, err := snapshotter.Stat(ctx, base)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return err
+		}
+		return c.createRemappedBase(ctx, snapshotter, base, chainID)
+	}
+	info.Labels = map[string]string{gcRootLabel: time.Now().UTC().Format(time.RFC3339)}
+	_, err = snapshotter.Update(ctx, info, "labels."+gcRootLabel)
+	if errdefs.IsNotFound(err) {
+		return c.createRemappedBase(ctx, snapshotter, base, chainID)
+	}
+	return err
+}
+
+// createRemappedBase prepares a snapshot of the image layers, changes the
+// owner of every file from its host ID to the host ID the client's mappings
+// give the same container ID, and commits it as the base snapshot
+func (c *cc) createRemappedBase(ctx context.Context, snapshotter snapshots.Snapshotter, base, chainID string) error {
+	log.Debugf("remapping image layers %s into snapshot %s", chainID, base)
+	uid, gid, err := idtools.GetRootUIDGID(c.idMappings.UIDs(), c.idMappings.GIDs())
+	if err != nil {
+		return err
+	}
+	// a unique active key, as other clients may be creating the same base
+	active := fmt.Sprintf("%s-%d", base, time.Now().UnixNano())
+	mounts, err := snapshotter.Prepare(ctx, active, chainID)
+	if err != nil {
+		return err
+	}
+	err = mount.WithTempMount(ctx, mounts, func(root string) error {
+		report, err := idtools.RemapOwnership(root, idtools.NewIDMappingsFromMaps(nil, nil), c.idMappings, idtools.RemapOptions{})
+		if err != nil {
+			return err
+		}
+		if len(report.Unmapped) > 0 {
+			u := report.Unmapped[0]
+			if rel, err := filepath.Rel(root, u.Path); err == nil {
+				u.Path = "/" + rel
+			}
+			return errors.Errorf("%d files are owned by IDs outside the ID mappings, such as %s", len(report.Unmapped), u)
+		}
+		return nil
+	})
+	if err != nil {
+		snapshotter.Remove(ctx, active)
+		return err
+	}
+
+	labels := map[string]string{
+		remapChainIDLabel: chainID,
+		remapUIDLabel:     strconv.Itoa(uid),
+		remapGIDLabel:     strconv.Itoa(gid),
+		remapUIDMapLabel:  idtools.FormatIDMap(c.idMappings.UIDs()),
+		remapGIDMapLabel:  idtools.FormatIDMap(c.idMappings.GIDs()),
+		gcRootLabel:       time.Now().UTC().Format(time.RFC3339),
+	}
+	if c.usernsUser != "" {
+		labels[remapUsernsLabel] = c.usernsUser
+	}
+	if err := snapshotter.Commit(ctx, base, active, snapshots.WithLabels(labels)); err != nil {
+		snapshotter.Remove(ctx, active)
+		// another client committed the same base snapshot first
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// pruneRemappedSnapshots removes the remapped base snapshots of the default
+// snapshotter which no container uses and whose image is gone, or whose ID
+// mappings are gone: the mappings of a --userns user are gone once its
+// subordinate ranges changed, and any other mappings once no container has
+// them. Snapshots created or chosen for a container within the grace period
+// are kept, as their container may not have prepared its snapshot yet. It
+// returns the keys of the removed snapshots.
+func (c *cc) pruneRemappedSnapshots(ctx context.Context) ([]string, error) {
+	images, err := c.client.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chainIDs := map[string]bool{}
+	for _, image := range images {
+		diffIDs, err := image.RootFS(ctx)
+		if err != nil {
+			log.Debugf("cannot read the layers of image %s: %v", image.Name(), err)
+			continue
+		}
+		chainIDs[identity.ChainID(diffIDs).String()] = true
+	}
+
+	snapshotter := c.client.SnapshotService(containerd.DefaultSnapshotter)
+	var (
+		bases   []snapshots.Info
+		parents = map[string]bool{}
+	)
+	err = snapshotter.Walk(ctx, func(ctx context.Context, info snapshots.Info) error {
+		parents[info.Parent] = true
+		if info.Labels[remapChainIDLabel] != "" {
+			bases = append(bases, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, info := range bases {
+		if parents[info.Name] || time.Since(info.Updated) < remapBaseGracePeriod {
+			continue
+		}
+		if chainIDs[info.Labels[remapChainIDLabel]] && remapUsersRanges(info.Labels) {
+			continue
+		}
+		if err := snapshotter.Remove(ctx, info.Name); err != nil {
+			if errdefs.IsNotFound(err) || errdefs.IsFailedPrecondition(err) {
+				// removed or used by another client meanwhile
+				continue
+			}
+			return removed, errors.Wrapf(err, "error removing snapshot %s", info.Name)
+		}
+		removed = append(removed, info.Name)
+	}
+	return removed, nil
+}
+
+// remapUsersRanges reports whether the labels of a remapped base snapshot
+// name a --userns user and group whose subordinate ranges are still the ones
+// the snapshot was remapped for
+func remapUsersRanges(labels map[string]string) bool {
+	if labels[remapUsernsLabel] == "" {
+		return false
+	}
+	username, groupname, err := parseUserGroup(labels[remapUsernsLabel])
+	if err != nil {
+		return false
+	}
+	idMappings, err := idtools.NewIDMappings(username, groupname)
+	if err != nil {
+		return false
+	}
+	return idtools.FormatIDMap(idMappings.UIDs()) == labels[remapUIDMapLabel] &&
+		idtools.FormatIDMap(idMappings.GIDs()) == labels[remapGIDMapLabel]
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/estesp/examplectr/idtools"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	// container label recording the pool user and group a container's ID
+	// ranges were allocated from
+	usernsAllocatedLabel = "examplectr.userns.allocated"
+
+	// file in the state directory recording the allocated ID ranges
+	usernsStateFile = "userns.json"
+)
+
+// usernsStatePath returns the path of the ID range allocation state
+func (c *cc) usernsStatePath() string {
+	return filepath.Join(c.stateDir, usernsStateFile)
+}
+
+// allocationOwner returns the owner of a container's ID range allocation,
+// which is unique across namespaces
+func (c *cc) allocationOwner(id string) string {
+	ns, _ := namespaces.Namespace(c.ctx)
+	return ns + "/" + id
+}
+
+// allocationStale reports whether the container an ID range allocation was
+// made for no longer exists
+func (c *cc) allocationStale(owner string) bool {
+	i := strings.IndexByte(owner, '/')
+	if i < 0 {
+		return false
+	}
+	ctx := namespaces.WithNamespace(c.ctx, owner[:i])
+	_, err := c.client.ContainerService().Get(ctx, owner[i+1:])
+	return errdefs.IsNotFound(err)
+}
+
+// allocateIDMappings allocates the client's container its own slice of the
+// ID ranges of the allocator's pool
+func (c *cc) allocateIDMappings() (*idtools.IDMappings, error) {
+	mappings, err := c.idAllocator.Allocate(c.allocationOwner(c.name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error allocating ID ranges for container %s", c.name)
+	}
+	return mappings, nil
+}
+
+// releaseIDMappings frees the ID ranges allocated to a container
+func (c *cc) releaseIDMappings(id string) {
+	if err := idtools.ReleaseRanges(c.usernsStatePath(), c.allocationOwner(id)); err != nil {
+		log.Errorf("error releasing ID ranges of container %s: %v", id, err)
+	}
+}
+
+// verifyIDMappings checks that the user namespace of the process pid has the
+// client's ID mappings. If its maps cannot be read, such as when containerd
+// runs in another PID namespace, a warning is logged instead.
+func (c *cc) verifyIDMappings(pid uint32) error {
+	uids, gids, err := idtools.ReadProcIDMaps(int(pid))
+	if err != nil {
+		log.Warnf("cannot verify the ID mappings of process %d: %v", pid, err)
+		return nil
+	}
+	if !idtools.EqualIDMaps(uids, c.idMappings.UIDs()) {
+		return errors.Errorf("process %d runs with UID mappings %s rather than %s", pid,
+			idtools.FormatIDMap(uids), idtools.FormatIDMap(c.idMappings.UIDs()))
+	}
+	if !idtools.EqualIDMaps(gids, c.idMappings.GIDs()) {
+		return errors.Errorf("process %d runs with GID mappings %s rather than %s", pid,
+			idtools.FormatIDMap(gids), idtools.FormatIDMap(c.idMappings.GIDs()))
+	}
+	return nil
+}
+
+var usernsCommand = cli.Command{
+	Name:  "userns",
+	Usage: "manage the users whose subordinate ID ranges containers are remapped to",
+	Subcommands: []cli.Command{
+		usernsAddCommand,
+		usernsListCommand,
+		usernsRemoveCommand,
+		usernsShowCommand,
+		usernsChownCommand,
+	},
+}
+
+var usernsAddCommand = cli.Command{
+	Name:      "add",
+	Usage:     "create a system user with subordinate UID and GID ranges, or give an existing user ranges",
+	ArgsUsage: "USER",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "size",
+			Usage: "number of IDs in each range (default: SUB_UID_COUNT/SUB_GID_COUNT from /etc/login.defs)",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		name := clicontext.Args().First()
+		if name == "" {
+			return errors.New("user name must be provided")
+		}
+		size := clicontext.Int("size")
+		if _, err := idtools.LookupUser(name); err != nil {
+			uid, gid, err := idtools.AddNamespaceRangesUserWithSize(name, size)
+			if err != nil {
+				return err
+			}
+			log.Infof("created user %s (%d:%d)", name, uid, gid)
+		} else if err := idtools.AddSubordinateRanges(name, size); err != nil {
+			return err
+		}
+		return printSubIDRanges(name)
+	},
+}
+
+var usernsListCommand = cli.Command{
+	Name:  "ls",
+	Usage: "list the subordinate ID ranges of all users and groups, flagging overlapping ranges",
+	Action: func(clicontext *cli.Context) error {
+		return printSubIDRanges("ALL")
+	},
+}
+
+var usernsRemoveCommand = cli.Command{
+	Name:      "rm",
+	Usage:     "remove users and their subordinate ID ranges",
+	ArgsUsage: "USER [USER...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "keep-user",
+			Usage: "only remove the user's subordinate ID ranges",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("at least one user name must be provided")
+		}
+		var exitErr error
+		for _, name := range clicontext.Args() {
+			var err error
+			if clicontext.Bool("keep-user") {
+				_, err = idtools.RemoveSubordinateRanges(name)
+			} else {
+				err = idtools.DelNamespaceRangesUser(name)
+			}
+			if err != nil {
+				log.Errorf("failed to remove user %s: %v", name, err)
+				exitErr = errors.New("failed to remove one or more users")
+				continue
+			}
+			fmt.Println(name)
+		}
+		return exitErr
+	},
+}
+
+var usernsShowCommand = cli.Command{
+	Name:      "show",
+	Usage:     "show the ID mappings containers run with --userns USER[:GROUP] get",
+	ArgsUsage: "USER[:GROUP]",
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("user name must be provided")
+		}
+		username, groupname, err := parseUserGroup(clicontext.Args().First())
+		if err != nil {
+			return err
+		}
+		idMappings, err := idtools.NewIDMappings(username, groupname)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "MAP\tCONTAINER\tHOST\tSIZE")
+		for _, m := range idMappings.UIDs() {
+			fmt.Fprintf(w, "uid\t%d\t%d\t%d\n", m.ContainerID, m.HostID, m.Size)
+		}
+		for _, m := range idMappings.GIDs() {
+			fmt.Fprintf(w, "gid\t%d\t%d\t%d\n", m.ContainerID, m.HostID, m.Size)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		uid, gid, err := idtools.GetRootUIDGID(idMappings.UIDs(), idMappings.GIDs())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("container root is host %d:%d\n", uid, gid)
+		return nil
+	},
+}
+
+var usernsChownCommand = cli.Command{
+	Name:      "chown",
+	Usage:     "remap the owners of the files under paths from one user's ID ranges to another's",
+	ArgsUsage: "PATH [PATH...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "USER[:GROUP] whose ranges the files are owned by (default: unmapped host IDs)",
+		},
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "USER[:GROUP] whose ranges the files are remapped to (default: unmapped host IDs)",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the changes without making them",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("at least one path must be provided")
+		}
+		if clicontext.String("from") == "" && clicontext.String("to") == "" {
+			return errors.New("--from or --to must be provided")
+		}
+		from, err := userMappings(clicontext.String("from"))
+		if err != nil {
+			return err
+		}
+		to, err := userMappings(clicontext.String("to"))
+		if err != nil {
+			return err
+		}
+		opts := idtools.RemapOptions{DryRun: clicontext.Bool("dry-run")}
+		if opts.DryRun {
+			opts.Changed = func(path string, from, to idtools.IDPair) {
+				fmt.Printf("%s\t%d:%d -> %d:%d\n", path, from.UID, from.GID, to.UID, to.GID)
+			}
+		}
+		var exitErr error
+		for _, path := range clicontext.Args() {
+			report, err := idtools.RemapOwnership(path, from, to, opts)
+			if err != nil {
+				log.Errorf("failed to remap %s: %v", path, err)
+				exitErr = errors.New("failed to remap one or more paths")
+			}
+			if report == nil {
+				continue
+			}
+			for _, u := range report.Unmapped {
+				log.Errorf("not remapped: %s", u)
+			}
+			if len(report.Unmapped) > 0 {
+				exitErr = errors.New("some files hold IDs outside the --from ranges or the --to ranges")
+			}
+			if !opts.DryRun {
+				log.Infof("remapped the owners of %d files under %s", report.Changed, path)
+			}
+		}
+		return exitErr
+	},
+}
+
+// userMappings returns the ID mappings of a USER[:GROUP], or empty mappings,
+// the host's own IDs, if s is empty
+func userMappings(s string) (*idtools.IDMappings, error) {
+	if s == "" {
+		return idtools.NewIDMappingsFromMaps(nil, nil), nil
+	}
+	username, groupname, err := parseUserGroup(s)
+	if err != nil {
+		return nil, err
+	}
+	return idtools.NewIDMappings(username, groupname)
+}
+
+// printSubIDRanges prints the subuid and subgid ranges of owner, or all of
+// them for "ALL", with any other ranges of the same file they overlap
+func printSubIDRanges(owner string) error {
+	w := tabwriter.NewWriter(os.Stdout, 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "FILE\tOWNER\tSTART\tLENGTH\tOVERLAPS")
+	subuid, subgid, err := idtools.ListSubordinateRanges()
+	if err != nil {
+		return err
+	}
+	for _, f := range []idtools.SubIDFile{subuid, subgid} {
+		owners := make(map[string]bool)
+		if owner != "ALL" {
+			for _, o := range f.Owners(owner) {
+				owners[o] = true
+			}
+		}
+		for i, r := range f.Ranges {
+			if owner != "ALL" && !owners[r.Owner] {
+				continue
+			}
+			var overlaps []string
+			for j, o := range f.Ranges {
+				if i != j && r.Start < o.Start+o.Length && o.Start < r.Start+r.Length {
+					overlaps = append(overlaps, o.String())
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", f.Path, r.Owner, r.Start, r.Length, strings.Join(overlaps, ","))
+		}
+	}
+	return w.Flush()
+}
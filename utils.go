@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/containers"
@@ -16,13 +17,14 @@ import (
 )
 
 // stopContainer will stop/kill a container (specifically, the tasks [processes]
-// running in the container)
-func stopContainer(ctx context.Context, client containerd.Client, name string) error {
-	container, err := client.LoadContainer(ctx, name)
+// running in the container), allowing the task the grace period to exit after
+// its stop signal before it is killed
+func (c *cc) stopContainer(name string, grace time.Duration) error {
+	container, err := c.client.LoadContainer(c.ctx, name)
 	if err != nil {
 		return err
 	}
-	if err = stopTask(ctx, container); err != nil {
+	if err = stopTask(c.ctx, container, grace); err != nil {
 		// ignore if the error is that the process had already exited:
 		if !strings.Contains(err.Error(), "not found") {
 			return err
@@ -31,17 +33,46 @@ func stopContainer(ctx context.Context, client containerd.Client, name string) e
 	return nil
 }
 
-// deleteContainer will remove a container
-func deleteContainer(ctx context.Context, client containerd.Client, name string) error {
-	container, err := client.LoadContainer(ctx, name)
+// deleteContainer will remove a container along with any log file written
+// for it and any ID ranges allocated to it
+func (c *cc) deleteContainer(name string) error {
+	container, err := c.client.LoadContainer(c.ctx, name)
 	if err != nil {
 		return err
 	}
-	return container.Delete(ctx, containerd.WithSnapshotCleanup)
+	return c.removeContainer(c.ctx, container)
 }
 
-// common code for task stop/kill using the containerd gRPC API
-func stopTask(ctx context.Context, ctr containerd.Container) error {
+// removeContainer removes a loaded container and its snapshot, then the
+// resources recorded in its labels
+func (c *cc) removeContainer(ctx context.Context, container containerd.Container) error {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return err
+	}
+	if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+		return err
+	}
+	if labels[usernsAllocatedLabel] != "" {
+		c.releaseIDMappings(container.ID())
+	}
+	if key := labels[idmappedRootfsLabel]; key != "" {
+		if err := c.unmountIDMappedRootfs(ctx, container.ID(), key); err != nil {
+			log.Warnf("error removing the ID-mapped rootfs of %s: %v", container.ID(), err)
+		}
+	}
+	if labels[remapBaseLabel] != "" {
+		if _, err := c.pruneRemappedSnapshots(ctx); err != nil {
+			log.Warnf("error pruning remapped snapshots: %v", err)
+		}
+	}
+	return removeLogs(labels)
+}
+
+// common code for task stop/kill using the containerd gRPC API. A running task
+// is sent its stop signal and killed if it hasn't exited within the grace
+// period; a paused task is resumed first so that it can handle the signal.
+func stopTask(ctx context.Context, ctr containerd.Container, grace time.Duration) error {
 	task, err := ctr.Task(ctx, nil)
 	if err != nil {
 		if !strings.Contains(err.Error(), "no running task") {
@@ -51,39 +82,100 @@ func stopTask(ctx context.Context, ctr containerd.Container) error {
 		return nil
 	}
 	status, err := task.Status(ctx)
+	if err != nil {
+		return err
+	}
 	switch status.Status {
 	case containerd.Stopped:
 		_, err := task.Delete(ctx)
 		if err != nil {
 			return err
 		}
-	case containerd.Running:
+	case containerd.Created:
+		// never started, so there is no process to signal
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+			return err
+		}
+	case containerd.Paused, containerd.Running:
 		statusC, err := task.Wait(ctx)
 		if err != nil {
-			log.Errorf("container %q: error during wait: %v", ctr.ID(), err)
+			return fmt.Errorf("container %q: error during wait: %v", ctr.ID(), err)
+		}
+		if status.Status == containerd.Paused {
+			if err := task.Resume(ctx); err != nil {
+				return fmt.Errorf("container %q: error resuming paused task: %v", ctr.ID(), err)
+			}
 		}
-		if err := task.Kill(ctx, syscall.SIGKILL); err != nil {
+		status, err := signalStop(ctx, ctr, task, statusC, grace)
+		if err != nil {
 			task.Delete(ctx)
 			return err
 		}
-		status := <-statusC
 		code, _, err := status.Result()
 		if err != nil {
 			log.Errorf("container %q: error getting task result code: %v", ctr.ID(), err)
 		}
 		if code != 0 {
-			log.Debugf("%s: exited container process: code: %d", ctr.ID(), status)
+			log.Debugf("%s: exited container process: code: %d", ctr.ID(), code)
 		}
 		_, err = task.Delete(ctx)
 		if err != nil {
 			return err
 		}
-	case containerd.Paused:
-		return fmt.Errorf("Can't stop a paused container; unpause first")
 	}
 	return nil
 }
 
+// signalStop sends the task its stop signal and, if it is still running once
+// the grace period has passed, SIGKILL. A zero grace period kills immediately.
+// The task's exit status is returned once it has exited.
+func signalStop(ctx context.Context, ctr containerd.Container, task containerd.Task, statusC <-chan containerd.ExitStatus, grace time.Duration) (containerd.ExitStatus, error) {
+	if grace > 0 {
+		sig, err := stopSignal(ctx, ctr)
+		if err != nil {
+			return containerd.ExitStatus{}, err
+		}
+		log.Debugf("%s: sending stop signal %s", ctr.ID(), sig)
+		if err := task.Kill(ctx, sig); err != nil {
+			return containerd.ExitStatus{}, err
+		}
+		select {
+		case status := <-statusC:
+			return status, nil
+		case <-time.After(grace):
+			log.Warnf("container %q: did not exit within %s; killing", ctr.ID(), grace)
+		}
+	}
+	if err := task.Kill(ctx, syscall.SIGKILL, containerd.WithKillAll); err != nil {
+		return containerd.ExitStatus{}, err
+	}
+	return <-statusC, nil
+}
+
+// stopSignal returns the signal used to stop a container: the stop signal
+// label set at creation, then the image's configured stop signal, and
+// finally SIGTERM
+func stopSignal(ctx context.Context, ctr containerd.Container) (syscall.Signal, error) {
+	labels, err := ctr.Labels(ctx)
+	if err != nil {
+		return -1, err
+	}
+	if _, ok := labels[containerd.StopSignalLabel]; ok {
+		return containerd.GetStopSignal(ctx, ctr, syscall.SIGTERM)
+	}
+	image, err := ctr.Image(ctx)
+	if err != nil {
+		// the image may have been removed since the container was created
+		log.Debugf("%s: no image for stop signal lookup: %v", ctr.ID(), err)
+		return syscall.SIGTERM, nil
+	}
+	sig, err := containerd.GetOCIStopSignal(ctx, image, "SIGTERM")
+	if err != nil {
+		return -1, err
+	}
+	return containerd.ParseSignal(sig)
+}
+
 // withMounts
 func withMounts() oci.SpecOpts {
 	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *specs.Spec) error {